@@ -0,0 +1,91 @@
+package main
+
+import (
+	"log"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var owCircuitBreakerOpen = prometheus.NewGauge(
+	prometheus.GaugeOpts{
+		Name: "ow_circuit_breaker_open",
+		Help: "Whether fetches are paused after repeated failures (1 = open, 0 = closed)",
+	},
+)
+
+func init() {
+	exporterRegistry.MustRegister(owCircuitBreakerOpen)
+}
+
+// circuitBreaker pauses fetching after a number of consecutive failed update
+// cycles. While open, cycles are skipped until the cooldown has passed, then a
+// single probe cycle is allowed. Each failed probe doubles the cooldown up to
+// maxCooldown, and a successful cycle closes the circuit again.
+type circuitBreaker struct {
+	mu           sync.Mutex
+	threshold    int
+	baseCooldown time.Duration
+	maxCooldown  time.Duration
+
+	failures  int
+	cooldown  time.Duration
+	openUntil time.Time
+}
+
+func newCircuitBreaker(threshold int, baseCooldown, maxCooldown time.Duration) *circuitBreaker {
+	return &circuitBreaker{
+		threshold:    threshold,
+		baseCooldown: baseCooldown,
+		maxCooldown:  maxCooldown,
+	}
+}
+
+// allow reports whether an update cycle should run at time now
+func (b *circuitBreaker) allow(now time.Time) bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.threshold <= 0 || !now.Before(b.openUntil)
+}
+
+// record updates the breaker with the outcome of an update cycle
+func (b *circuitBreaker) record(success bool, now time.Time) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.threshold <= 0 {
+		return
+	}
+	if success {
+		if b.cooldown > 0 {
+			log.Printf("Circuit breaker closed, OpenWeather requests succeeded again")
+		}
+		b.failures = 0
+		b.cooldown = 0
+		b.openUntil = time.Time{}
+		owCircuitBreakerOpen.Set(0)
+		return
+	}
+
+	b.failures++
+	if b.failures < b.threshold {
+		return
+	}
+	if b.cooldown == 0 {
+		b.cooldown = b.baseCooldown
+	} else {
+		b.cooldown = min(2*b.cooldown, b.maxCooldown)
+	}
+	b.openUntil = now.Add(b.cooldown)
+	owCircuitBreakerOpen.Set(1)
+	log.Printf("Circuit breaker open after %d consecutive failures, pausing requests for %s", b.failures, b.cooldown)
+}
+
+// closed reports whether the circuit is closed, it stays open after the
+// cooldown until a probe cycle succeeds
+func (b *circuitBreaker) closed() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.cooldown == 0
+}
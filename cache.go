@@ -0,0 +1,150 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// cacheFile is the path of the state kept across restarts, empty to disable
+var cacheFile string
+
+// cacheState is the content of CACHE_FILE
+type cacheState struct {
+	Usage *usageRollup `json:"usage,omitempty"`
+	// Counters maps counter names to their series, only with PERSIST_COUNTERS
+	Counters map[string][]counterSample `json:"counters,omitempty"`
+}
+
+// counterSample is the value of a single counter series
+type counterSample struct {
+	Labels map[string]string `json:"labels,omitempty"`
+	Value  float64           `json:"value"`
+}
+
+// persistCounters enables the snapshot of persistentCounters to CACHE_FILE
+var persistCounters bool
+
+// persistentCounters returns the counters kept across restarts with
+// PERSIST_COUNTERS, keyed by metric name
+func persistentCounters() map[string]*prometheus.CounterVec {
+	return map[string]*prometheus.CounterVec{
+		"ow_scrape_errors_total":             owScrapeErrors,
+		"ow_api_requests_total":              owAPIRequests,
+		"ow_weather_missing_condition_total": owWeatherMissingCondition,
+	}
+}
+
+// snapshotCounters returns the current values of the persistent counters
+func snapshotCounters() (map[string][]counterSample, error) {
+	families, err := exporterRegistry.Gather()
+	if err != nil {
+		return nil, err
+	}
+	counters := persistentCounters()
+	snapshot := make(map[string][]counterSample)
+	for _, family := range families {
+		if _, ok := counters[family.GetName()]; !ok {
+			continue
+		}
+		for _, m := range family.GetMetric() {
+			labels := make(map[string]string)
+			for _, label := range m.GetLabel() {
+				labels[label.GetName()] = label.GetValue()
+			}
+			snapshot[family.GetName()] = append(snapshot[family.GetName()], counterSample{Labels: labels, Value: m.GetCounter().GetValue()})
+		}
+	}
+	return snapshot, nil
+}
+
+// restoreCounters adds the snapshotted values to the persistent counters,
+// which must not have been incremented yet
+func restoreCounters(snapshot map[string][]counterSample) error {
+	counters := persistentCounters()
+	for name, samples := range snapshot {
+		counter, ok := counters[name]
+		if !ok {
+			continue
+		}
+		for _, sample := range samples {
+			c, err := counter.GetMetricWith(sample.Labels)
+			if err != nil {
+				return fmt.Errorf("failed to restore %s: %w", name, err)
+			}
+			c.Add(sample.Value)
+		}
+	}
+	return nil
+}
+
+// loadCache reads the cache file. A missing file is not an error and yields
+// an empty state.
+func loadCache(path string) (cacheState, error) {
+	var state cacheState
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return state, nil
+	}
+	if err != nil {
+		return state, fmt.Errorf("failed to read cache file: %w", err)
+	}
+	if err := json.Unmarshal(data, &state); err != nil {
+		return state, fmt.Errorf("failed to decode cache file: %w", err)
+	}
+	return state, nil
+}
+
+// saveCache writes the state to a temporary file and renames it over the
+// cache file, so a crash never leaves a partially written cache behind
+func saveCache(path string, state cacheState) error {
+	data, err := json.MarshalIndent(state, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode cache file: %w", err)
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return fmt.Errorf("failed to write cache file: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to write cache file: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("failed to write cache file: %w", err)
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return fmt.Errorf("failed to write cache file: %w", err)
+	}
+	return nil
+}
+
+// currentCacheState collects the state to persist
+func currentCacheState() cacheState {
+	u := currentUsage()
+	state := cacheState{Usage: &u}
+	if persistCounters {
+		counters, err := snapshotCounters()
+		if err != nil {
+			log.Printf("Error collecting counters for the cache: %v", err)
+		}
+		state.Counters = counters
+	}
+	return state
+}
+
+// persistCache saves the current state if CACHE_FILE is set
+func persistCache() {
+	if cacheFile == "" {
+		return
+	}
+	if err := saveCache(cacheFile, currentCacheState()); err != nil {
+		log.Printf("Error saving cache: %v", err)
+	}
+}
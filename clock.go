@@ -0,0 +1,39 @@
+package main
+
+import "time"
+
+// clock abstracts the current time and tickers so the update loop can be
+// driven deterministically
+type clock interface {
+	Now() time.Time
+	NewTicker(d time.Duration) ticker
+}
+
+// ticker is the subset of time.Ticker used by the update loop
+type ticker interface {
+	C() <-chan time.Time
+	Stop()
+}
+
+// clk is the clock of the exporter. Everything that stamps or compares times
+// of the update cycle uses it, so tests can swap in a fake clock.
+var clk clock = realClock{}
+
+// realClock is the clock backed by the time package
+type realClock struct{}
+
+func (realClock) Now() time.Time {
+	return time.Now()
+}
+
+func (realClock) NewTicker(d time.Duration) ticker {
+	return realTicker{time.NewTicker(d)}
+}
+
+type realTicker struct {
+	*time.Ticker
+}
+
+func (t realTicker) C() <-chan time.Time {
+	return t.Ticker.C
+}
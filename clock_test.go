@@ -0,0 +1,103 @@
+package main
+
+import (
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeClock is a clock whose time only moves with advance. Its tickers fire
+// when advance passes their next tick.
+type fakeClock struct {
+	mu      sync.Mutex
+	now     time.Time
+	tickers []*fakeTicker
+	// created receives every new ticker
+	created chan *fakeTicker
+}
+
+func newFakeClock(now time.Time) *fakeClock {
+	return &fakeClock{now: now, created: make(chan *fakeTicker, 16)}
+}
+
+// useFakeClock replaces the exporter clock with a fake one until the test ends
+func useFakeClock(t *testing.T, now time.Time) *fakeClock {
+	t.Helper()
+	fake := newFakeClock(now)
+	previous := clk
+	clk = fake
+	t.Cleanup(func() { clk = previous })
+	return fake
+}
+
+func (c *fakeClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+func (c *fakeClock) NewTicker(d time.Duration) ticker {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	t := &fakeTicker{c: make(chan time.Time, 1), period: d, next: c.now.Add(d)}
+	c.tickers = append(c.tickers, t)
+	c.created <- t
+	return t
+}
+
+// advance moves the time forward by d and fires the tickers that are due.
+// Like time.Ticker, ticks are dropped while the previous one is not received.
+func (c *fakeClock) advance(d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.now = c.now.Add(d)
+	for _, t := range c.tickers {
+		if t.stopped() {
+			continue
+		}
+		for !t.next.After(c.now) {
+			select {
+			case t.c <- t.next:
+			default:
+			}
+			t.next = t.next.Add(t.period)
+		}
+	}
+}
+
+// waitTicker waits until the code under test has created a ticker
+func (c *fakeClock) waitTicker(t *testing.T) *fakeTicker {
+	t.Helper()
+	select {
+	case ticker := <-c.created:
+		return ticker
+	case <-time.After(time.Second):
+		t.Fatal("no ticker was created")
+		return nil
+	}
+}
+
+type fakeTicker struct {
+	c      chan time.Time
+	period time.Duration
+	next   time.Time
+
+	mu     sync.Mutex
+	isStop bool
+}
+
+func (t *fakeTicker) C() <-chan time.Time {
+	return t.c
+}
+
+func (t *fakeTicker) Stop() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.isStop = true
+}
+
+func (t *fakeTicker) stopped() bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.isStop
+}
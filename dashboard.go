@@ -0,0 +1,335 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+)
+
+// dashboardMetric is an exporter metric shown on the generated dashboard
+type dashboardMetric struct {
+	name      string
+	help      string
+	labels    []string
+	histogram bool
+}
+
+// dashboardSections are the dashboard rows in order, by metric name prefix.
+// Metrics matching none of the prefixes are shown in the last row.
+var dashboardSections = []struct {
+	title  string
+	prefix string
+}{
+	{"Weather", "ow_weather_"},
+	{"Air Pollution", "ow_air_pollution_"},
+	{"Forecast", "ow_forecast"},
+	{"Solar Radiation", "ow_solar_"},
+	{"Exporter", "ow_"},
+}
+
+// Grafana dashboard JSON structures, limited to the fields that are set
+type grafanaDashboard struct {
+	Title         string            `json:"title"`
+	UID           string            `json:"uid"`
+	Tags          []string          `json:"tags"`
+	SchemaVersion int               `json:"schemaVersion"`
+	Refresh       string            `json:"refresh"`
+	Time          grafanaTimeRange  `json:"time"`
+	Templating    grafanaTemplating `json:"templating"`
+	Panels        []grafanaPanel    `json:"panels"`
+	Annotations   map[string]any    `json:"annotations"`
+	Editable      bool              `json:"editable"`
+	Links         []any             `json:"links"`
+}
+
+type grafanaTimeRange struct {
+	From string `json:"from"`
+	To   string `json:"to"`
+}
+
+type grafanaTemplating struct {
+	List []grafanaVariable `json:"list"`
+}
+
+type grafanaVariable struct {
+	Name       string             `json:"name"`
+	Label      string             `json:"label"`
+	Type       string             `json:"type"`
+	Query      string             `json:"query"`
+	Datasource *grafanaDatasource `json:"datasource,omitempty"`
+	Refresh    int                `json:"refresh,omitempty"`
+	Multi      bool               `json:"multi,omitempty"`
+	IncludeAll bool               `json:"includeAll,omitempty"`
+	Sort       int                `json:"sort,omitempty"`
+}
+
+type grafanaDatasource struct {
+	Type string `json:"type"`
+	UID  string `json:"uid"`
+}
+
+type grafanaPanel struct {
+	ID          int                `json:"id"`
+	Type        string             `json:"type"`
+	Title       string             `json:"title"`
+	Description string             `json:"description,omitempty"`
+	GridPos     grafanaGridPos     `json:"gridPos"`
+	Datasource  *grafanaDatasource `json:"datasource,omitempty"`
+	Targets     []grafanaTarget    `json:"targets,omitempty"`
+}
+
+type grafanaGridPos struct {
+	H int `json:"h"`
+	W int `json:"w"`
+	X int `json:"x"`
+	Y int `json:"y"`
+}
+
+type grafanaTarget struct {
+	RefID        string             `json:"refId"`
+	Expr         string             `json:"expr"`
+	LegendFormat string             `json:"legendFormat"`
+	Datasource   *grafanaDatasource `json:"datasource"`
+}
+
+// vecLabels maps the descriptor of each metric vector of the exporter to its
+// variable labels, which client_golang does not expose. Descriptors that are
+// not in it belong to metrics without variable labels.
+var vecLabels = make(map[*prometheus.Desc][]string)
+
+// recordLabels records the variable labels of a metric vector in vecLabels
+func recordLabels(vec prometheus.Collector, labels []string) {
+	for _, desc := range collectDescs(vec) {
+		vecLabels[desc] = labels
+	}
+}
+
+// newGaugeVec is prometheus.NewGaugeVec, recording the labels for the dashboard
+func newGaugeVec(opts prometheus.GaugeOpts, labels []string) *prometheus.GaugeVec {
+	vec := prometheus.NewGaugeVec(opts, labels)
+	recordLabels(vec, labels)
+	return vec
+}
+
+// newCounterVec is prometheus.NewCounterVec, recording the labels for the
+// dashboard
+func newCounterVec(opts prometheus.CounterOpts, labels []string) *prometheus.CounterVec {
+	vec := prometheus.NewCounterVec(opts, labels)
+	recordLabels(vec, labels)
+	return vec
+}
+
+// newHistogramVec is prometheus.NewHistogramVec, recording the labels for the
+// dashboard
+func newHistogramVec(opts prometheus.HistogramOpts, labels []string) *prometheus.HistogramVec {
+	vec := prometheus.NewHistogramVec(opts, labels)
+	recordLabels(vec, labels)
+	return vec
+}
+
+// newSummaryVec is prometheus.NewSummaryVec, recording the labels for the
+// dashboard
+func newSummaryVec(opts prometheus.SummaryOpts, labels []string) *prometheus.SummaryVec {
+	vec := prometheus.NewSummaryVec(opts, labels)
+	recordLabels(vec, labels)
+	return vec
+}
+
+// placeholderCollector collects one placeholder series for every descriptor,
+// with the variable labels recorded in vecLabels, so the name, help, and
+// labels of the descriptors can be read from a gather. client_golang does not
+// expose them otherwise, except in the unstable string form of a descriptor.
+// Descriptors of histograms get a histogram series.
+type placeholderCollector struct {
+	descs      []*prometheus.Desc
+	histograms map[*prometheus.Desc]bool
+}
+
+func (c placeholderCollector) Describe(ch chan<- *prometheus.Desc) {
+	for _, desc := range c.descs {
+		ch <- desc
+	}
+}
+
+func (c placeholderCollector) Collect(ch chan<- prometheus.Metric) {
+	for _, desc := range c.descs {
+		values := make([]string, len(vecLabels[desc]))
+		var m prometheus.Metric
+		var err error
+		if c.histograms[desc] {
+			m, err = prometheus.NewConstHistogram(desc, 0, 0, nil, values...)
+		} else {
+			m, err = prometheus.NewConstMetric(desc, prometheus.UntypedValue, 0, values...)
+		}
+		if err != nil {
+			// Fails the gather instead of leaving the metric out
+			m = prometheus.NewInvalidMetric(desc, err)
+		}
+		ch <- m
+	}
+}
+
+// describeMetrics lists the exporter metrics of the collector, sorted by name.
+// Info metrics are left out, their value is always 1. Descriptors do not tell
+// the type of a metric, so the histograms among them are passed separately.
+func describeMetrics(collector prometheus.Collector, histograms ...prometheus.Collector) ([]dashboardMetric, error) {
+	placeholders := placeholderCollector{
+		descs:      collectDescs(collector),
+		histograms: make(map[*prometheus.Desc]bool),
+	}
+	for _, histogram := range histograms {
+		for _, desc := range collectDescs(histogram) {
+			placeholders.histograms[desc] = true
+		}
+	}
+	registry := prometheus.NewRegistry()
+	if err := registry.Register(placeholders); err != nil {
+		return nil, fmt.Errorf("failed to describe metrics: %w", err)
+	}
+	families, err := registry.Gather()
+	if err != nil {
+		return nil, fmt.Errorf("failed to describe metrics: %w", err)
+	}
+
+	// Gather sorts the families by name
+	var metrics []dashboardMetric
+	for _, family := range families {
+		name := family.GetName()
+		if !strings.HasPrefix(name, "ow_") || strings.HasSuffix(name, "_info") {
+			continue
+		}
+		metric := dashboardMetric{
+			name:      name,
+			help:      family.GetHelp(),
+			histogram: family.GetType() == dto.MetricType_HISTOGRAM,
+		}
+		for _, m := range family.GetMetric() {
+			for _, label := range m.GetLabel() {
+				metric.labels = append(metric.labels, label.GetName())
+			}
+		}
+		metrics = append(metrics, metric)
+	}
+	return metrics, nil
+}
+
+// collectDescs returns the descriptors of a collector
+func collectDescs(collector prometheus.Collector) []*prometheus.Desc {
+	ch := make(chan *prometheus.Desc)
+	go func() {
+		collector.Describe(ch)
+		close(ch)
+	}()
+	var descs []*prometheus.Desc
+	for desc := range ch {
+		descs = append(descs, desc)
+	}
+	return descs
+}
+
+// dashboardQuery returns the PromQL query and legend of a metric's panel.
+// Counters are shown as per second rates and histograms as their 90th
+// percentile.
+func dashboardQuery(metric dashboardMetric) (string, string) {
+	var legend []string
+	selector := ""
+	for _, label := range metric.labels {
+		if label == "station" {
+			selector = `{station=~"$station"}`
+		}
+		legend = append(legend, "{{"+label+"}}")
+	}
+	expr := metric.name + selector
+	switch {
+	case metric.histogram:
+		// The 90th percentile of each series, aggregated over the buckets
+		by := append([]string{"le"}, metric.labels...)
+		expr = fmt.Sprintf("histogram_quantile(0.9, sum by (%s) (rate(%s_bucket%s[$__rate_interval])))", strings.Join(by, ", "), metric.name, selector)
+	case strings.HasSuffix(metric.name, "_total"):
+		expr = "rate(" + expr + "[$__rate_interval])"
+	}
+	return expr, strings.Join(legend, " ")
+}
+
+// newDashboard builds a dashboard with a row per section and a time series
+// panel per metric, two panels side by side
+func newDashboard(metrics []dashboardMetric) grafanaDashboard {
+	datasource := &grafanaDatasource{Type: "prometheus", UID: "${datasource}"}
+	dashboard := grafanaDashboard{
+		Title:         "OpenWeather Exporter",
+		UID:           "openweather-exporter",
+		Tags:          []string{"openweather"},
+		SchemaVersion: 39,
+		Refresh:       "5m",
+		Time:          grafanaTimeRange{From: "now-24h", To: "now"},
+		Annotations:   map[string]any{"list": []any{}},
+		Editable:      true,
+		Links:         []any{},
+		Templating: grafanaTemplating{List: []grafanaVariable{
+			{Name: "datasource", Label: "Data source", Type: "datasource", Query: "prometheus"},
+			{
+				Name:       "station",
+				Label:      "Station",
+				Type:       "query",
+				Query:      "label_values(ow_station_info, station)",
+				Datasource: datasource,
+				Refresh:    2,
+				Multi:      true,
+				IncludeAll: true,
+				Sort:       1,
+			},
+		}},
+	}
+
+	y := 0
+	done := make(map[string]bool)
+	for _, section := range dashboardSections {
+		var panels []grafanaPanel
+		for _, metric := range metrics {
+			if done[metric.name] || !strings.HasPrefix(metric.name, section.prefix) {
+				continue
+			}
+			done[metric.name] = true
+			expr, legend := dashboardQuery(metric)
+			panels = append(panels, grafanaPanel{
+				Type:        "timeseries",
+				Title:       metric.name,
+				Description: metric.help,
+				GridPos:     grafanaGridPos{H: 8, W: 12, X: 12 * (len(panels) % 2), Y: y + 1 + 8*(len(panels)/2)},
+				Datasource:  datasource,
+				Targets:     []grafanaTarget{{RefID: "A", Expr: expr, LegendFormat: legend, Datasource: datasource}},
+			})
+		}
+		if len(panels) == 0 {
+			continue
+		}
+		dashboard.Panels = append(dashboard.Panels, grafanaPanel{
+			Type:    "row",
+			Title:   section.title,
+			GridPos: grafanaGridPos{H: 1, W: 24, X: 0, Y: y},
+		})
+		dashboard.Panels = append(dashboard.Panels, panels...)
+		y += 1 + 8*((len(panels)+1)/2)
+	}
+	for i := range dashboard.Panels {
+		dashboard.Panels[i].ID = i + 1
+	}
+	return dashboard
+}
+
+// writeDashboard writes a Grafana dashboard with the exporter metrics
+// registered with the collector as JSON. The histograms among them must be
+// passed separately, descriptors do not tell the type of a metric.
+func writeDashboard(w io.Writer, collector prometheus.Collector, histograms ...prometheus.Collector) error {
+	metrics, err := describeMetrics(collector, histograms...)
+	if err != nil {
+		return err
+	}
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(newDashboard(metrics))
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"slices"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+func TestDescribeMetrics(t *testing.T) {
+	registry := prometheus.NewRegistry()
+	gauge := newGaugeVec(prometheus.GaugeOpts{Name: "ow_test_temp", Help: "Test temperature"}, []string{"station", "unit"})
+	counter := prometheus.NewCounter(prometheus.CounterOpts{Name: "ow_test_errors_total", Help: "Test errors"})
+	info := newGaugeVec(prometheus.GaugeOpts{Name: "ow_test_info", Help: "Test info"}, []string{"station"})
+	histogram := newScrapeDurationHistogram(prometheus.DefBuckets, false)
+	registry.MustRegister(gauge, counter, info, histogram)
+
+	metrics, err := describeMetrics(registry, histogram)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []dashboardMetric{
+		{name: "ow_scrape_duration_seconds", help: "Duration of OpenWeather API requests in seconds", labels: []string{"endpoint"}, histogram: true},
+		{name: "ow_test_errors_total", help: "Test errors"},
+		{name: "ow_test_temp", help: "Test temperature", labels: []string{"station", "unit"}},
+	}
+	if len(metrics) != len(want) {
+		t.Fatalf("describeMetrics = %+v, want %+v", metrics, want)
+	}
+	for i := range want {
+		got := metrics[i]
+		if got.name != want[i].name || got.help != want[i].help || !slices.Equal(got.labels, want[i].labels) || got.histogram != want[i].histogram {
+			t.Errorf("metric %d = %+v, want %+v", i, got, want[i])
+		}
+	}
+}
+
+func TestDashboardQuery(t *testing.T) {
+	tests := []struct {
+		metric dashboardMetric
+		expr   string
+		legend string
+	}{
+		{
+			dashboardMetric{name: "ow_weather_humidity", labels: []string{"station"}},
+			`ow_weather_humidity{station=~"$station"}`,
+			"{{station}}",
+		},
+		{
+			dashboardMetric{name: "ow_scrape_errors_total", labels: []string{"endpoint", "reason"}},
+			"rate(ow_scrape_errors_total[$__rate_interval])",
+			"{{endpoint}} {{reason}}",
+		},
+		{
+			dashboardMetric{name: "ow_scrape_duration_seconds", labels: []string{"endpoint"}, histogram: true},
+			"histogram_quantile(0.9, sum by (le, endpoint) (rate(ow_scrape_duration_seconds_bucket[$__rate_interval])))",
+			"{{endpoint}}",
+		},
+	}
+	for _, tt := range tests {
+		expr, legend := dashboardQuery(tt.metric)
+		if expr != tt.expr || legend != tt.legend {
+			t.Errorf("dashboardQuery(%s) = %q, %q, want %q, %q", tt.metric.name, expr, legend, tt.expr, tt.legend)
+		}
+	}
+}
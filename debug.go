@@ -0,0 +1,105 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"net/url"
+	"sort"
+	"sync"
+	"time"
+)
+
+// rawResponse is the last body received from an OpenWeather endpoint
+type rawResponse struct {
+	Endpoint  string          `json:"endpoint"`
+	URL       string          `json:"url"`
+	FetchedAt time.Time       `json:"fetched_at"`
+	Body      json.RawMessage `json:"body"`
+}
+
+var (
+	// debugEndpoints enables /debug/raw and the capture of response bodies
+	debugEndpoints bool
+
+	rawResponsesMu sync.Mutex
+	rawResponses   = make(map[string]rawResponse)
+)
+
+// debugLogging enables debug messages with LOG_LEVEL=debug
+var debugLogging bool
+
+// debugf logs a message only with LOG_LEVEL=debug
+func debugf(format string, args ...any) {
+	if debugLogging {
+		log.Printf("Debug: "+format, args...)
+	}
+}
+
+// maxRedirects is the number of redirects followed, like the default client
+const maxRedirects = 10
+
+// logRedirect is the CheckRedirect of the HTTP client. It logs every
+// redirect, which is usually a proxy or OPENWEATHER_BASE_URL pointing
+// somewhere unexpected.
+func logRedirect(req *http.Request, via []*http.Request) error {
+	if len(via) >= maxRedirects {
+		return errors.New("stopped after 10 redirects")
+	}
+	debugf("Following redirect from %s to %s", redactURL(via[len(via)-1].URL.String()), redactURL(req.URL.String()))
+	return nil
+}
+
+// redactURL replaces the API key in an OpenWeather URL
+func redactURL(rawURL string) string {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return "<invalid url>"
+	}
+	q := u.Query()
+	if q.Has("appid") {
+		q.Set("appid", "REDACTED")
+		u.RawQuery = q.Encode()
+	}
+	return u.String()
+}
+
+// recordRawResponse keeps the most recent body received for each request URL
+func recordRawResponse(endpoint, requestURL string, body []byte) {
+	if !debugEndpoints || !json.Valid(body) {
+		return
+	}
+	redacted := redactURL(requestURL)
+
+	rawResponsesMu.Lock()
+	defer rawResponsesMu.Unlock()
+	rawResponses[redacted] = rawResponse{
+		Endpoint:  endpoint,
+		URL:       redacted,
+		FetchedAt: clk.Now(),
+		Body:      append(json.RawMessage(nil), body...),
+	}
+}
+
+// handleDebugRaw serves the captured response bodies as pretty-printed JSON
+func handleDebugRaw(w http.ResponseWriter, r *http.Request) {
+	rawResponsesMu.Lock()
+	responses := make([]rawResponse, 0, len(rawResponses))
+	for _, response := range rawResponses {
+		responses = append(responses, response)
+	}
+	rawResponsesMu.Unlock()
+
+	sort.Slice(responses, func(i, j int) bool {
+		return responses[i].URL < responses[j].URL
+	})
+
+	out, err := json.MarshalIndent(responses, "", "  ")
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(out)
+}
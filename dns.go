@@ -0,0 +1,74 @@
+package main
+
+import (
+	"errors"
+	"math/rand/v2"
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var owDNSErrors = prometheus.NewCounter(
+	prometheus.CounterOpts{
+		Name: "ow_dns_errors_total",
+		Help: "Total number of OpenWeather requests that failed to resolve the host name, including retried ones",
+	},
+)
+
+func init() {
+	exporterRegistry.MustRegister(owDNSErrors)
+}
+
+var (
+	// dnsRetries is how often a request is retried after a DNS failure, set
+	// with DNS_RETRIES
+	dnsRetries = 3
+	// dnsRetryDelay is the base delay before the first DNS retry, doubled on
+	// every further one, set with DNS_RETRY_DELAY
+	dnsRetryDelay = 500 * time.Millisecond
+)
+
+// isDNSError reports whether a request failed to resolve the host name
+func isDNSError(err error) bool {
+	var dnsErr *net.DNSError
+	return errors.As(err, &dnsErr)
+}
+
+// doWithDNSRetry sends req with the client and retries it after DNS
+// failures, which usually clear within seconds, e.g. while the resolver is
+// still starting at boot. Other errors are returned right away. The delays are
+// jittered so exporters on the same host do not retry in lockstep.
+func doWithDNSRetry(client *http.Client, req *http.Request) (*http.Response, error) {
+	for attempt := 0; ; attempt++ {
+		resp, err := client.Do(req)
+		if err == nil || !isDNSError(err) {
+			return resp, err
+		}
+		owDNSErrors.Inc()
+		if attempt >= dnsRetries {
+			return nil, err
+		}
+
+		delay := dnsRetryDelay << attempt
+		if delay > 0 {
+			delay = delay/2 + rand.N(delay/2+1)
+		}
+		debugf("Retrying %s in %s after DNS failure: %s", req.URL.Host, delay, redactError(err))
+		timer := time.NewTimer(delay)
+		select {
+		case <-req.Context().Done():
+			timer.Stop()
+			return nil, err
+		case <-timer.C:
+		}
+		if req.GetBody != nil {
+			body, bodyErr := req.GetBody()
+			if bodyErr != nil {
+				return nil, err
+			}
+			req.Body = body
+		}
+	}
+}
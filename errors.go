@@ -0,0 +1,80 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+)
+
+// StatusError is returned when an OpenWeather API answers with a status other
+// than 200, e.g. 401 for an invalid key or 429 when rate limited
+type StatusError struct {
+	Name string
+	Code int
+}
+
+func (e *StatusError) Error() string {
+	return fmt.Sprintf("%s API returned status code: %d", e.Name, e.Code)
+}
+
+// DecodeError is returned when a response is not the expected JSON
+type DecodeError struct {
+	Name string
+	Err  error
+}
+
+func (e *DecodeError) Error() string {
+	return fmt.Sprintf("failed to decode %s response: %v", e.Name, e.Err)
+}
+
+func (e *DecodeError) Unwrap() error {
+	return e.Err
+}
+
+// TimeoutError is returned when a request did not finish in time
+type TimeoutError struct {
+	Name string
+	Err  error
+}
+
+func (e *TimeoutError) Error() string {
+	return fmt.Sprintf("%s request timed out: %v", e.Name, e.Err)
+}
+
+func (e *TimeoutError) Unwrap() error {
+	return e.Err
+}
+
+// TooLargeError is returned when a response body exceeds MAX_RESPONSE_BYTES
+type TooLargeError struct {
+	Name  string
+	Limit int64
+}
+
+func (e *TooLargeError) Error() string {
+	return fmt.Sprintf("%s response exceeds the limit of %d bytes", e.Name, e.Limit)
+}
+
+// isTimeout reports whether a request error was caused by a deadline
+func isTimeout(err error) bool {
+	var netErr net.Error
+	return errors.Is(err, context.DeadlineExceeded) || (errors.As(err, &netErr) && netErr.Timeout())
+}
+
+// errorReason classifies a request error for the reason label of
+// ow_scrape_errors_total
+func errorReason(err error) string {
+	var statusErr *StatusError
+	var decodeErr *DecodeError
+	var tooLargeErr *TooLargeError
+	switch {
+	case errors.As(err, &statusErr):
+		return "status"
+	case errors.As(err, &decodeErr):
+		return "decode"
+	case errors.As(err, &tooLargeErr):
+		return "too_large"
+	}
+	return "http"
+}
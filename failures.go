@@ -0,0 +1,68 @@
+package main
+
+import (
+	"log"
+	"sync"
+	"time"
+)
+
+// failureState is the ongoing failure streak of one kind of fetch
+type failureState struct {
+	count      int
+	since      time.Time
+	lastLogged time.Time
+	suppressed int
+}
+
+// failureLog keeps the log readable during long outages. The first failure
+// of a fetch is logged, repeats are suppressed and summarized every
+// `every` failures or after `interval`, and the recovery is logged once the
+// fetch succeeds again.
+type failureLog struct {
+	mu       sync.Mutex
+	every    int
+	interval time.Duration
+	states   map[string]*failureState
+}
+
+func newFailureLog(every int, interval time.Duration) *failureLog {
+	return &failureLog{
+		every:    every,
+		interval: interval,
+		states:   make(map[string]*failureState),
+	}
+}
+
+// failures is the failure log of all OpenWeather fetches, configured by
+// ERROR_LOG_EVERY and ERROR_LOG_INTERVAL
+var failures = newFailureLog(12, time.Hour)
+
+// report logs the outcome of fetching what, e.g. "weather data for station
+// 123", according to the suppression policy. A nil err marks a success.
+func (l *failureLog) report(what string, err error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	now := clk.Now()
+
+	state, failing := l.states[what]
+	if err == nil {
+		if failing {
+			log.Printf("Recovered fetching %s after %d failures in %s", what, state.count, now.Sub(state.since).Round(time.Second))
+			delete(l.states, what)
+		}
+		return
+	}
+
+	if !failing {
+		l.states[what] = &failureState{count: 1, since: now, lastLogged: now}
+		log.Printf("Error fetching %s: %v", what, err)
+		return
+	}
+	state.count++
+	state.suppressed++
+	if (l.every > 0 && state.suppressed >= l.every) || (l.interval > 0 && now.Sub(state.lastLogged) >= l.interval) {
+		log.Printf("Error fetching %s: %v (failing since %s, %d failures, %d repeats suppressed)", what, err, state.since.Format(time.RFC3339), state.count, state.suppressed)
+		state.lastLogged = now
+		state.suppressed = 0
+	}
+}
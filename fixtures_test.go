@@ -0,0 +1,131 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+)
+
+func TestMain(m *testing.M) {
+	// main registers these metrics after reading the configuration
+	owScrapeDuration = newScrapeDurationHistogram(prometheus.DefBuckets, false)
+	registerUnitMetrics("")
+	os.Exit(m.Run())
+}
+
+// fixtureFiles maps the paths of the OpenWeather endpoints to the fixtures in
+// testdata served for them
+var fixtureFiles = map[string]string{
+	"/data/2.5/weather":          "weather.json",
+	"/data/2.5/group":            "group.json",
+	"/data/2.5/air_pollution":    "air_pollution.json",
+	"/data/2.5/forecast":         "forecast.json",
+	"/data/2.5/forecast/daily":   "forecast_daily.json",
+	"/data/2.5/solar_radiation":  "solar_radiation.json",
+	"/data/3.0/onecall/overview": "onecall_overview.json",
+}
+
+// fixtureServer answers OpenWeather requests with the fixtures in testdata by
+// path and records the requests. Handlers set with handle replace the fixture
+// of a path, e.g. to serve an error.
+type fixtureServer struct {
+	*httptest.Server
+
+	mu       sync.Mutex
+	handlers map[string]http.HandlerFunc
+	requests []*url.URL
+}
+
+// newFixtureServer starts a fixture server and points the API base URLs at it
+// until the test ends
+func newFixtureServer(t *testing.T) *fixtureServer {
+	t.Helper()
+	s := &fixtureServer{handlers: make(map[string]http.HandlerFunc)}
+	s.Server = httptest.NewServer(http.HandlerFunc(s.serve))
+	t.Cleanup(s.Close)
+
+	previousBase, previousPro := apiBaseURL, proBaseURL
+	apiBaseURL, proBaseURL = s.URL, s.URL
+	t.Cleanup(func() { apiBaseURL, proBaseURL = previousBase, previousPro })
+	return s
+}
+
+// handle replaces the fixture served for path
+func (s *fixtureServer) handle(path string, handler http.HandlerFunc) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.handlers[path] = handler
+}
+
+// requested returns the URLs of all requests for path, in order
+func (s *fixtureServer) requested(path string) []*url.URL {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var urls []*url.URL
+	for _, u := range s.requests {
+		if u.Path == path {
+			urls = append(urls, u)
+		}
+	}
+	return urls
+}
+
+func (s *fixtureServer) serve(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	s.requests = append(s.requests, r.URL)
+	handler := s.handlers[r.URL.Path]
+	s.mu.Unlock()
+
+	if handler != nil {
+		handler(w, r)
+		return
+	}
+	file, ok := fixtureFiles[r.URL.Path]
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+	serveFixture(w, file)
+}
+
+// serveFixture writes a fixture from testdata as a JSON response
+func serveFixture(w http.ResponseWriter, file string) {
+	body, err := os.ReadFile(filepath.Join("testdata", file))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.Write(body)
+}
+
+// stationSeries returns the number of series of the collector labeled with
+// the station
+func stationSeries(t *testing.T, c prometheus.Collector, station string) int {
+	t.Helper()
+	metrics := make(chan prometheus.Metric)
+	go func() {
+		c.Collect(metrics)
+		close(metrics)
+	}()
+	n := 0
+	for m := range metrics {
+		var pb dto.Metric
+		if err := m.Write(&pb); err != nil {
+			t.Fatal(err)
+		}
+		for _, label := range pb.GetLabel() {
+			if label.GetName() == "station" && label.GetValue() == station {
+				n++
+			}
+		}
+	}
+	return n
+}
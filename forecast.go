@@ -0,0 +1,269 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"sync"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// 5 day / 3 hour Forecast API response structures
+type ForecastResponse struct {
+	Cnt  int `json:"cnt"`
+	List []struct {
+		Dt   int64 `json:"dt"`
+		Main struct {
+			Temp      float64 `json:"temp"`
+			FeelsLike float64 `json:"feels_like"`
+			TempMin   float64 `json:"temp_min"`
+			TempMax   float64 `json:"temp_max"`
+			Pressure  float64 `json:"pressure"`
+			Humidity  float64 `json:"humidity"`
+		} `json:"main"`
+		Weather []struct {
+			ID          int    `json:"id"`
+			Main        string `json:"main"`
+			Description string `json:"description"`
+			Icon        string `json:"icon"`
+		} `json:"weather"`
+		Pop float64 `json:"pop"`
+	} `json:"list"`
+	City struct {
+		ID       int    `json:"id"`
+		Name     string `json:"name"`
+		Timezone int    `json:"timezone"`
+	} `json:"city"`
+}
+
+// 16 day daily Forecast API response structures
+type DailyForecastResponse struct {
+	Cnt  int `json:"cnt"`
+	List []struct {
+		Dt   int64 `json:"dt"`
+		Temp struct {
+			Day   float64 `json:"day"`
+			Min   float64 `json:"min"`
+			Max   float64 `json:"max"`
+			Night float64 `json:"night"`
+			Eve   float64 `json:"eve"`
+			Morn  float64 `json:"morn"`
+		} `json:"temp"`
+		Pressure float64 `json:"pressure"`
+		Humidity float64 `json:"humidity"`
+		Pop      float64 `json:"pop"`
+	} `json:"list"`
+	City struct {
+		ID       int    `json:"id"`
+		Name     string `json:"name"`
+		Timezone int    `json:"timezone"`
+	} `json:"city"`
+}
+
+// forecastStepHours is the time between entries of the forecast list
+const forecastStepHours = 3
+
+var (
+	// forecastEnabled enables the forecast fetch for every location
+	forecastEnabled bool
+	// forecastPoints is the number of forecast entries requested and exported
+	// per station, at most the 40 entries of the full 5 day forecast. 0 means
+	// unset, the full forecast is requested.
+	forecastPoints int
+	// daily16Enabled enables the 16 day daily forecast fetch for every location
+	daily16Enabled bool
+	// daily16Days is the number of days requested from the daily forecast
+	daily16Days = 7
+)
+
+// Forecast metrics
+var (
+	owForecastPrecipitationProbability = newGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "ow_forecast_precipitation_probability",
+			Help: "Probability of precipitation (0-1)",
+		},
+		[]string{"station", "horizon"},
+	)
+
+	owForecast16TempDay = newGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "ow_forecast16_temp_day",
+			Help: "Forecast day temperature",
+		},
+		[]string{"station", "day_offset", "unit"},
+	)
+
+	owForecast16TempNight = newGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "ow_forecast16_temp_night",
+			Help: "Forecast night temperature",
+		},
+		[]string{"station", "day_offset", "unit"},
+	)
+
+	owForecast16TempMin = newGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "ow_forecast16_temp_min",
+			Help: "Forecast minimum daily temperature",
+		},
+		[]string{"station", "day_offset", "unit"},
+	)
+
+	owForecast16TempMax = newGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "ow_forecast16_temp_max",
+			Help: "Forecast maximum daily temperature",
+		},
+		[]string{"station", "day_offset", "unit"},
+	)
+)
+
+// owForecastTempError compares cached forecasts with the observed temperature
+var owForecastTempError = newGaugeVec(
+	prometheus.GaugeOpts{
+		Name: "ow_forecast_temp_error",
+		Help: "Forecast minus observed temperature for the most recent observation",
+	},
+	[]string{"station", "unit"},
+)
+
+func init() {
+	exporterRegistry.MustRegister(owForecastPrecipitationProbability)
+	exporterRegistry.MustRegister(owForecastTempError)
+	exporterRegistry.MustRegister(owForecast16TempDay)
+	exporterRegistry.MustRegister(owForecast16TempNight)
+	exporterRegistry.MustRegister(owForecast16TempMin)
+	exporterRegistry.MustRegister(owForecast16TempMax)
+}
+
+func forecastURL(latitude, longitude, apiKey, units string) string {
+	if forecastPoints == 0 {
+		return fmt.Sprintf("%s/data/2.5/forecast?lat=%s&lon=%s&appid=%s&units=%s&mode=json", apiBaseURL, latitude, longitude, apiKey, units)
+	}
+	// cnt limits the list server-side to the entries that are exported
+	return fmt.Sprintf("%s/data/2.5/forecast?lat=%s&lon=%s&cnt=%d&appid=%s&units=%s&mode=json", apiBaseURL, latitude, longitude, forecastPoints, apiKey, units)
+}
+
+// forecastHorizon returns the horizon label of the i-th forecast entry
+func forecastHorizon(i int) string {
+	return fmt.Sprintf("%dh", (i+1)*forecastStepHours)
+}
+
+func fetchForecastData(ctx context.Context, url string, station string) error {
+	var forecast ForecastResponse
+	if err := fetchJSON(ctx, "forecast", "forecast", url, &forecast); err != nil {
+		return err
+	}
+
+	// Update forecast metrics, dropping the horizons of the previous forecast
+	// first in case FORECAST_POINTS was lowered or fewer entries returned
+	unlock := lockStation(station)
+	owForecastPrecipitationProbability.DeletePartialMatch(prometheus.Labels{"station": station})
+	for i, entry := range forecast.List {
+		if forecastPoints > 0 && i >= forecastPoints {
+			break
+		}
+		owForecastPrecipitationProbability.WithLabelValues(station, forecastHorizon(i)).Set(entry.Pop)
+	}
+	unlock()
+	forecastTemps.store(station, forecast)
+
+	return nil
+}
+
+// forecastCache keeps the latest forecast temperature per station and valid
+// time, so it can be compared with the observation once that time arrives
+type forecastCache struct {
+	mu    sync.Mutex
+	temps map[string]map[int64]float64
+}
+
+var forecastTemps = &forecastCache{temps: make(map[string]map[int64]float64)}
+
+// store caches the temperatures of a forecast, replacing older forecasts for
+// the same valid times
+func (c *forecastCache) store(station string, forecast ForecastResponse) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	temps, ok := c.temps[station]
+	if !ok {
+		temps = make(map[int64]float64)
+		c.temps[station] = temps
+	}
+	for _, entry := range forecast.List {
+		temps[entry.Dt] = entry.Main.Temp
+	}
+}
+
+// forget drops the cached forecasts of the station
+func (c *forecastCache) forget(station string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	delete(c.temps, station)
+}
+
+// match returns the cached forecast closest to the observation time, if one
+// is valid within half a forecast step, and drops forecasts that are too old
+// to match later observations
+func (c *forecastCache) match(station string, observed int64) (float64, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	const window = forecastStepHours * 3600 / 2
+	var temp float64
+	best := int64(window + 1)
+	for valid, t := range c.temps[station] {
+		diff := valid - observed
+		if diff < -window {
+			delete(c.temps[station], valid)
+			continue
+		}
+		if diff < 0 {
+			diff = -diff
+		}
+		if diff < best {
+			best, temp = diff, t
+		}
+	}
+	return temp, best <= window
+}
+
+// updateForecastError sets the difference between the forecast for the
+// observation time and the observed temperature
+func updateForecastError(station, units string, observed int64, temp float64) {
+	if forecast, ok := forecastTemps.match(station, observed); ok {
+		owForecastTempError.WithLabelValues(station, units).Set(roundValue(forecast - temp))
+	}
+}
+
+func daily16URL(latitude, longitude, apiKey, units string) string {
+	return fmt.Sprintf("%s/data/2.5/forecast/daily?lat=%s&lon=%s&cnt=%d&appid=%s&units=%s&mode=json", proBaseURL, latitude, longitude, daily16Days, apiKey, units)
+}
+
+func fetchDaily16Data(ctx context.Context, url, station, units string) error {
+	var forecast DailyForecastResponse
+	if err := fetchJSON(ctx, "forecast_daily", "daily forecast", url, &forecast); err != nil {
+		return err
+	}
+
+	// Day offset 0 is today in the timezone of the city. The offsets of the
+	// previous forecast are dropped first in case DAILY16_DAYS was lowered,
+	// fewer days were returned, or the units of the location changed.
+	defer lockStation(station)()
+	for _, vec := range []seriesDeleter{owForecast16TempDay, owForecast16TempNight, owForecast16TempMin, owForecast16TempMax} {
+		vec.DeletePartialMatch(prometheus.Labels{"station": station})
+	}
+	for i, day := range forecast.List {
+		if i >= daily16Days {
+			break
+		}
+		offset := strconv.Itoa(i)
+		owForecast16TempDay.WithLabelValues(station, offset, units).Set(roundValue(day.Temp.Day))
+		owForecast16TempNight.WithLabelValues(station, offset, units).Set(roundValue(day.Temp.Night))
+		owForecast16TempMin.WithLabelValues(station, offset, units).Set(roundValue(day.Temp.Min))
+		owForecast16TempMax.WithLabelValues(station, offset, units).Set(roundValue(day.Temp.Max))
+	}
+
+	return nil
+}
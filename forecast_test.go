@@ -0,0 +1,93 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+	"sync/atomic"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+func TestForecastURLCount(t *testing.T) {
+	previous := forecastPoints
+	t.Cleanup(func() { forecastPoints = previous })
+
+	tests := []struct {
+		points int
+		cnt    string
+	}{
+		{0, ""},
+		{8, "8"},
+		{40, "40"},
+	}
+	for _, tt := range tests {
+		forecastPoints = tt.points
+		u, err := url.Parse(forecastURL("40.015", "-105.2705", "secret", "metric"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := u.Query().Get("cnt"); got != tt.cnt {
+			t.Errorf("FORECAST_POINTS=%d: cnt = %q, want %q", tt.points, got, tt.cnt)
+		}
+	}
+}
+
+func TestFetchForecastDataPoints(t *testing.T) {
+	server := newFixtureServer(t)
+	previous := forecastPoints
+	t.Cleanup(func() { forecastPoints = previous })
+
+	const station = "forecast-test"
+	t.Cleanup(func() { deleteStationSeries(station) })
+
+	// The fixture has 3 entries. The horizons of the previous forecast must
+	// not remain when fewer points are exported.
+	tests := []struct {
+		points int
+		want   int
+	}{
+		{0, 3},
+		{2, 2},
+		{1, 1},
+	}
+	for _, tt := range tests {
+		forecastPoints = tt.points
+		if err := fetchForecastData(context.Background(), server.URL+"/data/2.5/forecast", station); err != nil {
+			t.Fatal(err)
+		}
+		if got := stationSeries(t, owForecastPrecipitationProbability, station); got != tt.want {
+			t.Errorf("FORECAST_POINTS=%d: %d series, want %d", tt.points, got, tt.want)
+		}
+	}
+}
+
+func TestFetchDaily16DataFewerDays(t *testing.T) {
+	server := newFixtureServer(t)
+	const station = "daily16-test"
+	t.Cleanup(func() { deleteStationSeries(station) })
+
+	// The fixture has 2 days, the second response only 1
+	var short atomic.Bool
+	server.handle("/data/2.5/forecast/daily", func(w http.ResponseWriter, r *http.Request) {
+		if !short.Load() {
+			serveFixture(w, "forecast_daily.json")
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"cod":"200","cnt":1,"list":[{"dt":1760637600,"temp":{"day":290.4,"min":281.2,"max":291.8,"night":283.1}}]}`))
+	})
+
+	for _, want := range []int{2, 1} {
+		if err := fetchDaily16Data(context.Background(), server.URL+"/data/2.5/forecast/daily", station, "standard"); err != nil {
+			t.Fatal(err)
+		}
+		for _, vec := range []*prometheus.GaugeVec{owForecast16TempDay, owForecast16TempNight, owForecast16TempMin, owForecast16TempMax} {
+			if got := stationSeries(t, vec, station); got != want {
+				t.Errorf("%d series for station %s, want %d", got, station, want)
+			}
+		}
+		short.Store(true)
+	}
+}
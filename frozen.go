@@ -0,0 +1,55 @@
+package main
+
+import (
+	"sync"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var owWeatherIdenticalReadings = newGaugeVec(
+	prometheus.GaugeOpts{
+		Name: "ow_weather_identical_readings",
+		Help: "Number of consecutive updates that returned the same temperature as the one before, 0 after it changed",
+	},
+	[]string{"station"},
+)
+
+func init() {
+	exporterRegistry.MustRegister(owWeatherIdenticalReadings)
+}
+
+// identicalReading is the last temperature of a station and how often in a
+// row it was repeated
+type identicalReading struct {
+	temp    float64
+	repeats int
+}
+
+var (
+	identicalReadingsMu sync.Mutex
+	identicalReadings   = make(map[string]identicalReading)
+)
+
+// updateIdenticalReadings counts how often in a row the station returned the
+// same temperature. A station that never changes is likely reporting stale
+// data, even though the requests succeed.
+func updateIdenticalReadings(station string, temp float64) {
+	identicalReadingsMu.Lock()
+	defer identicalReadingsMu.Unlock()
+	reading, ok := identicalReadings[station]
+	if ok && reading.temp == temp {
+		reading.repeats++
+	} else {
+		reading = identicalReading{temp: temp}
+	}
+	identicalReadings[station] = reading
+	owWeatherIdenticalReadings.WithLabelValues(station).Set(float64(reading.repeats))
+}
+
+// forgetIdenticalReadings drops the remembered temperature once the
+// station's series have been deleted
+func forgetIdenticalReadings(station string) {
+	identicalReadingsMu.Lock()
+	defer identicalReadingsMu.Unlock()
+	delete(identicalReadings, station)
+}
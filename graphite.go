@@ -0,0 +1,118 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// graphiteWriter sends metrics to Graphite/Carbon in the plaintext protocol
+type graphiteWriter struct {
+	address string
+	prefix  string
+}
+
+// graphite is the configured Graphite writer, nil when GRAPHITE_ADDRESS is unset
+var graphite *graphiteWriter
+
+// graphiteTimeout bounds connecting to and writing to Carbon
+const graphiteTimeout = 10 * time.Second
+
+func newGraphiteWriter(address, prefix string) (*graphiteWriter, error) {
+	if _, _, err := net.SplitHostPort(address); err != nil {
+		return nil, fmt.Errorf("invalid GRAPHITE_ADDRESS: %w", err)
+	}
+	return &graphiteWriter{address: address, prefix: strings.TrimSuffix(prefix, ".")}, nil
+}
+
+// graphiteUnsafe matches characters that are not allowed in a path node
+var graphiteUnsafe = regexp.MustCompile(`[^A-Za-z0-9_-]`)
+
+// graphitePath builds the path of a series as prefix.station.metric followed
+// by the values of any other labels in name order
+func graphitePath(prefix, metric string, labels map[string]string) string {
+	nodes := []string{prefix}
+	if station, ok := labels["station"]; ok {
+		nodes = append(nodes, station)
+	}
+	nodes = append(nodes, metric)
+	names := make([]string, 0, len(labels))
+	for name := range labels {
+		if name != "station" {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		nodes = append(nodes, labels[name])
+	}
+	for i, node := range nodes {
+		nodes[i] = graphiteUnsafe.ReplaceAllString(node, "_")
+	}
+	return strings.Join(nodes, ".")
+}
+
+// graphiteLines formats all exporter gauges and counters as plaintext lines
+func (w *graphiteWriter) lines(gatherer prometheus.Gatherer, now time.Time) ([]string, error) {
+	families, err := gatherer.Gather()
+	if err != nil {
+		return nil, err
+	}
+	timestamp := strconv.FormatInt(now.Unix(), 10)
+	var lines []string
+	for _, family := range families {
+		if !strings.HasPrefix(family.GetName(), "ow_") {
+			continue
+		}
+		for _, m := range family.GetMetric() {
+			var value float64
+			switch {
+			case m.GetGauge() != nil:
+				value = m.GetGauge().GetValue()
+			case m.GetCounter() != nil:
+				value = m.GetCounter().GetValue()
+			default:
+				// Histograms have no single value
+				continue
+			}
+			labels := make(map[string]string)
+			for _, label := range m.GetLabel() {
+				labels[label.GetName()] = label.GetValue()
+			}
+			path := graphitePath(w.prefix, family.GetName(), labels)
+			lines = append(lines, path+" "+strconv.FormatFloat(value, 'f', -1, 64)+" "+timestamp)
+		}
+	}
+	return lines, nil
+}
+
+// write sends the current metrics to Carbon over a new TCP connection
+func (w *graphiteWriter) write(ctx context.Context) error {
+	lines, err := w.lines(metricsGatherer, clk.Now())
+	if err != nil {
+		return fmt.Errorf("failed to gather metrics for Graphite: %w", err)
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, graphiteTimeout)
+	defer cancel()
+	var dialer net.Dialer
+	conn, err := dialer.DialContext(ctx, "tcp", w.address)
+	if err != nil {
+		return fmt.Errorf("failed to connect to Graphite: %w", err)
+	}
+	defer conn.Close()
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetWriteDeadline(deadline)
+	}
+	if _, err := conn.Write([]byte(strings.Join(lines, "\n") + "\n")); err != nil {
+		return fmt.Errorf("failed to write to Graphite: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// apiKeyValid mirrors ow_api_key_valid for the health summary
+var apiKeyValid atomic.Bool
+
+// setAPIKeyValid records whether OpenWeather accepted the API key
+func setAPIKeyValid(valid bool) {
+	apiKeyValid.Store(valid)
+	if valid {
+		owAPIKeyValid.Set(1)
+	} else {
+		owAPIKeyValid.Set(0)
+	}
+}
+
+// exporterHealth combines the internal signals into ow_exporter_healthy: an
+// update cycle succeeded within maxAge, the API key is valid, and the circuit
+// breaker is closed.
+type exporterHealth struct {
+	mu          sync.Mutex
+	maxAge      time.Duration
+	breaker     *circuitBreaker
+	lastSuccess time.Time
+}
+
+func newExporterHealth(maxAge time.Duration, breaker *circuitBreaker) *exporterHealth {
+	return &exporterHealth{maxAge: maxAge, breaker: breaker}
+}
+
+// record updates the health with the outcome of an update cycle at time now
+func (h *exporterHealth) record(success bool, now time.Time) {
+	if !success {
+		return
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.lastSuccess = now
+}
+
+// healthy reports whether all signals are healthy at time now
+func (h *exporterHealth) healthy(now time.Time) bool {
+	h.mu.Lock()
+	recent := !h.lastSuccess.IsZero() && now.Sub(h.lastSuccess) <= h.maxAge
+	h.mu.Unlock()
+	return recent && apiKeyValid.Load() && h.breaker.closed()
+}
+
+// gauge returns ow_exporter_healthy, evaluated on every scrape so it drops
+// to 0 when updates stop altogether
+func (h *exporterHealth) gauge(clk clock) prometheus.GaugeFunc {
+	return prometheus.NewGaugeFunc(
+		prometheus.GaugeOpts{
+			Name: "ow_exporter_healthy",
+			Help: "Whether an update succeeded within two scrape intervals, the API key is valid, and the circuit breaker is closed (1) or not (0)",
+		},
+		func() float64 {
+			if h.healthy(clk.Now()) {
+				return 1
+			}
+			return 0
+		},
+	)
+}
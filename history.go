@@ -0,0 +1,187 @@
+package main
+
+import (
+	"strings"
+	"sync"
+	"time"
+)
+
+// historySamples is the number of samples kept per station for derived metrics
+var historySamples = 12
+
+// sample is a single timestamped reading
+type sample struct {
+	Time  time.Time
+	Value float64
+}
+
+// ringBuffer keeps the most recent samples up to a fixed capacity
+type ringBuffer struct {
+	samples []sample
+	start   int
+}
+
+func newRingBuffer(size int) *ringBuffer {
+	return &ringBuffer{samples: make([]sample, 0, size)}
+}
+
+// Add appends a sample, replacing the oldest one once the buffer is full
+func (r *ringBuffer) Add(s sample) {
+	if len(r.samples) < cap(r.samples) {
+		r.samples = append(r.samples, s)
+		return
+	}
+	r.samples[r.start] = s
+	r.start = (r.start + 1) % len(r.samples)
+}
+
+// Samples returns a copy of the buffered samples from oldest to newest
+func (r *ringBuffer) Samples() []sample {
+	out := make([]sample, 0, len(r.samples))
+	out = append(out, r.samples[r.start:]...)
+	return append(out, r.samples[:r.start]...)
+}
+
+// Latest returns the most recently added sample
+func (r *ringBuffer) Latest() (sample, bool) {
+	if len(r.samples) == 0 {
+		return sample{}, false
+	}
+	if len(r.samples) < cap(r.samples) || r.start == 0 {
+		return r.samples[len(r.samples)-1], true
+	}
+	return r.samples[r.start-1], true
+}
+
+// stationHistory keeps a ring buffer of historySamples samples per station.
+// It is safe for concurrent use and lives in memory only, so it starts empty
+// after a restart.
+type stationHistory struct {
+	mu      sync.Mutex
+	buffers map[string]*ringBuffer
+}
+
+func newStationHistory() *stationHistory {
+	return &stationHistory{buffers: make(map[string]*ringBuffer)}
+}
+
+// Add records a value observed at t for the station and returns the station's
+// samples from oldest to newest. Samples that are not newer than the latest
+// one are ignored, since OpenWeather only refreshes observations every few
+// minutes and repeats would distort rates.
+func (h *stationHistory) Add(station string, t time.Time, value float64) []sample {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	buffer, ok := h.buffers[station]
+	if !ok {
+		buffer = newRingBuffer(historySamples)
+		h.buffers[station] = buffer
+	}
+	if latest, ok := buffer.Latest(); !ok || t.After(latest.Time) {
+		buffer.Add(sample{Time: t, Value: value})
+	}
+	return buffer.Samples()
+}
+
+// forget drops the samples of the station, including those kept under keys
+// of the form station/units
+func (h *stationHistory) forget(station string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for key := range h.buffers {
+		if key == station || strings.HasPrefix(key, station+"/") {
+			delete(h.buffers, key)
+		}
+	}
+}
+
+// movingAverage keeps an exponential moving average per station. Like
+// stationHistory it is safe for concurrent use and starts empty after a restart.
+type movingAverage struct {
+	mu     sync.Mutex
+	values map[string]float64
+}
+
+func newMovingAverage() *movingAverage {
+	return &movingAverage{values: make(map[string]float64)}
+}
+
+// Update folds value into the station's average as alpha*value + (1-alpha)*previous
+// and returns the new average. The first value seeds the average.
+func (m *movingAverage) Update(station string, value, alpha float64) float64 {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if previous, ok := m.values[station]; ok {
+		value = alpha*value + (1-alpha)*previous
+	}
+	m.values[station] = value
+	return value
+}
+
+// forget drops the average of the station, so the next value seeds a new one
+func (m *movingAverage) forget(station string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.values, station)
+}
+
+// dailyAccumulator integrates an hourly rate per station into a total that
+// resets at local midnight. Like stationHistory it is safe for concurrent use
+// and starts empty after a restart.
+type dailyAccumulator struct {
+	mu     sync.Mutex
+	totals map[string]*dailyTotal
+}
+
+// dailyTotal is the accumulated total of a station since local midnight
+type dailyTotal struct {
+	last  time.Time
+	total float64
+}
+
+func newDailyAccumulator() *dailyAccumulator {
+	return &dailyAccumulator{totals: make(map[string]*dailyTotal)}
+}
+
+// Add integrates rate, given per hour, over the time since the previous
+// observation and returns the total since midnight in zone. The interval is
+// capped at one hour, since hourly readings such as rain_1h overlap when
+// polled more often and do not cover longer gaps. Observations that are not
+// newer than the previous one are ignored.
+func (a *dailyAccumulator) Add(station string, observed time.Time, zone *time.Location, rate float64) float64 {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	d, ok := a.totals[station]
+	if !ok {
+		d = &dailyTotal{}
+		a.totals[station] = d
+	}
+	if !observed.After(d.last) {
+		return d.total
+	}
+
+	local := observed.In(zone)
+	midnight := time.Date(local.Year(), local.Month(), local.Day(), 0, 0, 0, 0, zone)
+	if d.last.Before(midnight) {
+		d.total = 0
+	}
+	if !d.last.IsZero() {
+		start := d.last
+		if start.Before(midnight) {
+			start = midnight
+		}
+		d.total += rate * min(observed.Sub(start), time.Hour).Hours()
+	}
+	d.last = observed
+	return d.total
+}
+
+// forget drops the total of the station
+func (a *dailyAccumulator) forget(station string) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	delete(a.totals, station)
+}
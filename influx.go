@@ -0,0 +1,156 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"maps"
+	"net/http"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// influxWriter writes data points to an InfluxDB v2 write endpoint. Points are
+// queued while OpenWeather is queried and written in one request after each
+// update cycle, so a slow InfluxDB does not delay the updates.
+type influxWriter struct {
+	writeURL string
+	token    string
+
+	mu      sync.Mutex
+	pending []string
+}
+
+// influxTimeout bounds a write to InfluxDB
+const influxTimeout = 10 * time.Second
+
+// influx is the configured InfluxDB writer, nil when INFLUXDB_URL is unset
+var influx *influxWriter
+
+func newInfluxWriter(baseURL, token, org, bucket string) (*influxWriter, error) {
+	if org == "" || bucket == "" {
+		return nil, fmt.Errorf("INFLUXDB_ORG and INFLUXDB_BUCKET must be set with INFLUXDB_URL")
+	}
+	u, err := url.Parse(strings.TrimSuffix(baseURL, "/") + "/api/v2/write")
+	if err != nil {
+		return nil, fmt.Errorf("invalid INFLUXDB_URL: %w", err)
+	}
+	q := u.Query()
+	q.Set("org", org)
+	q.Set("bucket", bucket)
+	q.Set("precision", "s")
+	u.RawQuery = q.Encode()
+	return &influxWriter{writeURL: u.String(), token: token}, nil
+}
+
+// influxTagEscaper escapes tag values for the line protocol
+var influxTagEscaper = strings.NewReplacer(",", `\,`, "=", `\=`, " ", `\ `)
+
+// influxLine formats a single line protocol point with a station tag
+func influxLine(measurement, station string, fields map[string]float64, timestamp int64) string {
+	var b strings.Builder
+	b.WriteString(measurement)
+	b.WriteString(",station=")
+	b.WriteString(influxTagEscaper.Replace(station))
+	sep := " "
+	for _, name := range slices.Sorted(maps.Keys(fields)) {
+		b.WriteString(sep)
+		b.WriteString(name)
+		b.WriteString("=")
+		b.WriteString(strconv.FormatFloat(fields[name], 'f', -1, 64))
+		sep = ","
+	}
+	b.WriteString(" ")
+	b.WriteString(strconv.FormatInt(timestamp, 10))
+	return b.String()
+}
+
+// queue adds line protocol points to be written by the next flush
+func (w *influxWriter) queue(lines ...string) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.pending = append(w.pending, lines...)
+}
+
+// flush writes all queued points. Points that could not be written are
+// dropped, so the queue does not grow while InfluxDB is down.
+func (w *influxWriter) flush(ctx context.Context) error {
+	w.mu.Lock()
+	lines := w.pending
+	w.pending = nil
+	w.mu.Unlock()
+	if len(lines) == 0 {
+		return nil
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, influxTimeout)
+	defer cancel()
+	return w.write(ctx, lines...)
+}
+
+// write posts line protocol data to InfluxDB
+func (w *influxWriter) write(ctx context.Context, lines ...string) error {
+	body := strings.Join(lines, "\n")
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, w.writeURL, bytes.NewBufferString(body))
+	if err != nil {
+		return fmt.Errorf("failed to create InfluxDB request: %w", err)
+	}
+	req.Header.Set("Content-Type", "text/plain; charset=utf-8")
+	if w.token != "" {
+		req.Header.Set("Authorization", "Token "+w.token)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to write to InfluxDB: %w", err)
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, resp.Body)
+
+	if resp.StatusCode != http.StatusNoContent && resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("InfluxDB returned status code: %d", resp.StatusCode)
+	}
+	return nil
+}
+
+// queueWeather queues a decoded weather response as a "weather" point
+func (w *influxWriter) queueWeather(station string, weather *WeatherResponse) {
+	w.queue(influxLine("weather", station, map[string]float64{
+		"temp":       weather.Main.Temp,
+		"feels_like": weather.Main.FeelsLike,
+		"temp_min":   weather.Main.TempMin,
+		"temp_max":   weather.Main.TempMax,
+		"pressure":   weather.Main.Pressure,
+		"humidity":   weather.Main.Humidity,
+		"sea_level":  weather.Main.SeaLevel,
+		"grnd_level": weather.Main.GrndLevel,
+		"visibility": weather.Visibility,
+		"wind_speed": weather.Wind.Speed,
+		"wind_deg":   weather.Wind.Deg,
+		"clouds":     weather.Clouds.All,
+	}, weather.Dt))
+}
+
+// queueAirPollution queues the current entry of an air pollution response as
+// an "air_pollution" point
+func (w *influxWriter) queueAirPollution(station string, pollution *AirPollutionResponse) {
+	if len(pollution.List) == 0 {
+		return
+	}
+	data := pollution.List[0]
+	w.queue(influxLine("air_pollution", station, map[string]float64{
+		"aqi":   float64(data.Main.AQI),
+		"co":    data.Components.CO,
+		"no":    data.Components.NO,
+		"no2":   data.Components.NO2,
+		"o3":    data.Components.O3,
+		"so2":   data.Components.SO2,
+		"pm2_5": data.Components.PM25,
+		"pm10":  data.Components.PM10,
+		"nh3":   data.Components.NH3,
+	}, data.Dt))
+}
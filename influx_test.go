@@ -0,0 +1,53 @@
+package main
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestInfluxFlush(t *testing.T) {
+	var bodies []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if got := r.Header.Get("Authorization"); got != "Token secret" {
+			t.Errorf("Authorization = %q, want %q", got, "Token secret")
+		}
+		body, _ := io.ReadAll(r.Body)
+		bodies = append(bodies, string(body))
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer server.Close()
+
+	writer, err := newInfluxWriter(server.URL, "secret", "org", "bucket")
+	if err != nil {
+		t.Fatal(err)
+	}
+	weather := &WeatherResponse{Dt: 1760620000}
+	weather.Main.Temp = 288.15
+	writer.queueWeather("5574991", weather)
+	writer.queueAirPollution("5574991", &AirPollutionResponse{})
+	if len(bodies) != 0 {
+		t.Fatal("points written before the flush")
+	}
+
+	if err := writer.flush(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if len(bodies) != 1 {
+		t.Fatalf("flush sent %d requests, want 1", len(bodies))
+	}
+	if want := "weather,station=5574991 "; !strings.HasPrefix(bodies[0], want) || !strings.Contains(bodies[0], "temp=288.15") {
+		t.Errorf("body = %q, want a weather point of the station", bodies[0])
+	}
+
+	// Nothing is queued, so nothing is sent
+	if err := writer.flush(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if len(bodies) != 1 {
+		t.Errorf("empty flush sent a request")
+	}
+}
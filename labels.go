@@ -0,0 +1,106 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+)
+
+// labelNamePattern matches valid Prometheus label names
+var labelNamePattern = regexp.MustCompile(`^[a-zA-Z_][a-zA-Z0-9_]*$`)
+
+// validateLocationLabels checks the extra labels configured for a location
+func validateLocationLabels(labels map[string]string) error {
+	for name := range labels {
+		if !labelNamePattern.MatchString(name) || strings.HasPrefix(name, "__") {
+			return fmt.Errorf("invalid label name %q", name)
+		}
+	}
+	return nil
+}
+
+var (
+	stationLabelsMu sync.Mutex
+	// stationLabels maps stations to the extra labels of their location
+	stationLabels = make(map[string]map[string]string)
+)
+
+// setStationLabels sets the extra labels added to all series of the station,
+// nil removes them
+func setStationLabels(station string, labels map[string]string) {
+	stationLabelsMu.Lock()
+	defer stationLabelsMu.Unlock()
+	if len(labels) == 0 {
+		delete(stationLabels, station)
+		return
+	}
+	stationLabels[station] = labels
+}
+
+// stationLabelGatherer adds the extra labels of each location to all series
+// of its station. Labels are added at gather time because const labels are
+// fixed when a metric is created, while the metrics are shared by all
+// locations. A label is not added to series that already have one of the
+// same name.
+type stationLabelGatherer struct {
+	prometheus.Gatherer
+}
+
+func (g stationLabelGatherer) Gather() ([]*dto.MetricFamily, error) {
+	families, err := g.Gatherer.Gather()
+
+	stationLabelsMu.Lock()
+	defer stationLabelsMu.Unlock()
+	if len(stationLabels) == 0 {
+		return families, err
+	}
+	for _, family := range families {
+		for _, m := range family.GetMetric() {
+			extra := stationLabels[metricStation(m)]
+			if len(extra) == 0 {
+				continue
+			}
+			for name, value := range extra {
+				if !hasLabel(m, name) {
+					m.Label = append(m.Label, &dto.LabelPair{Name: &name, Value: &value})
+				}
+			}
+			sort.Slice(m.Label, func(i, j int) bool {
+				return m.Label[i].GetName() < m.Label[j].GetName()
+			})
+		}
+	}
+	return families, err
+}
+
+// metricStation returns the station label of a series, if any
+func metricStation(m *dto.Metric) string {
+	for _, label := range m.GetLabel() {
+		if label.GetName() == "station" {
+			return label.GetValue()
+		}
+	}
+	return ""
+}
+
+// hasLabel reports whether a series has a label of the given name
+func hasLabel(m *dto.Metric, name string) bool {
+	for _, label := range m.GetLabel() {
+		if label.GetName() == name {
+			return true
+		}
+	}
+	return false
+}
+
+// exporterGatherer collects the exporter metrics and, unless
+// DISABLE_GO_COLLECTORS is set, the Go and process metrics
+var exporterGatherer prometheus.Gatherer = prometheus.Gatherers{prometheus.DefaultGatherer, exporterRegistry}
+
+// metricsGatherer collects the metrics served on /metrics and sent to Graphite
+var metricsGatherer prometheus.Gatherer = stationLabelGatherer{exporterGatherer}
@@ -0,0 +1,146 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// locationsFile is the set of locations loaded from LOCATIONS_FILE. It is
+// re-read when the modification time of the file changes or on SIGHUP.
+type locationsFile struct {
+	path   string
+	apiKey string
+
+	mu        sync.Mutex
+	modTime   time.Time
+	locations []location
+}
+
+// locationEntry is a location in a JSON locations file
+type locationEntry struct {
+	Lat   float64 `json:"lat"`
+	Lon   float64 `json:"lon"`
+	Name  string  `json:"name"`
+	Units string  `json:"units"`
+	// Labels are added to all series of the location
+	Labels map[string]string `json:"labels"`
+}
+
+// parseLocationsFile parses a locations file. Files ending in .json hold an
+// array of {"lat", "lon", "name", "units", "labels"} objects, all other files
+// have one latitude,longitude[,name[,units]] entry per line like LOCATIONS.
+// Blank lines and lines starting with # are ignored.
+func parseLocationsFile(path string, data []byte) ([]location, error) {
+	if strings.EqualFold(filepath.Ext(path), ".json") {
+		var entries []locationEntry
+		if err := json.Unmarshal(data, &entries); err != nil {
+			return nil, fmt.Errorf("failed to decode %s: %w", path, err)
+		}
+		if len(entries) == 0 {
+			return nil, fmt.Errorf("no locations given")
+		}
+		locations := make([]location, 0, len(entries))
+		for _, entry := range entries {
+			if entry.Units != "" && !validUnits(entry.Units) {
+				return nil, fmt.Errorf("location %q has invalid units %q", entry.Name, entry.Units)
+			}
+			if err := validateLocationLabels(entry.Labels); err != nil {
+				return nil, fmt.Errorf("location %q: %w", entry.Name, err)
+			}
+			loc := location{
+				Latitude:  strconv.FormatFloat(entry.Lat, 'f', -1, 64),
+				Longitude: strconv.FormatFloat(entry.Lon, 'f', -1, 64),
+				Name:      entry.Name,
+				Units:     entry.Units,
+				Labels:    entry.Labels,
+			}
+			if err := loc.normalizeCoordinates(); err != nil {
+				return nil, fmt.Errorf("location %q: %w", entry.Name, err)
+			}
+			locations = append(locations, loc)
+		}
+		return locations, nil
+	}
+
+	var entries []string
+	for _, line := range strings.Split(string(data), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		entries = append(entries, line)
+	}
+	return parseLocations(strings.Join(entries, ";"))
+}
+
+// locationKey identifies a configured location
+func locationKey(loc location) string {
+	return loc.Latitude + "," + loc.Longitude
+}
+
+// reload re-reads the file if it changed since the last load, or always when
+// force is set. Locations that are no longer listed have their series
+// deleted. On error the previous locations are kept.
+func (f *locationsFile) reload(force bool) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	info, err := os.Stat(f.path)
+	if err != nil {
+		return fmt.Errorf("failed to read locations file: %w", err)
+	}
+	if !force && info.ModTime().Equal(f.modTime) {
+		return nil
+	}
+	data, err := os.ReadFile(f.path)
+	if err != nil {
+		return fmt.Errorf("failed to read locations file: %w", err)
+	}
+	locations, err := parseLocationsFile(f.path, data)
+	if err != nil {
+		return fmt.Errorf("invalid locations file: %w", err)
+	}
+
+	kept := make(map[string]bool, len(locations))
+	for i := range locations {
+		if locations[i].Units == "" {
+			locations[i].Units = units
+		}
+		locations[i].buildURLs(f.apiKey)
+		addLocationStatus(locations[i])
+		kept[locationKey(locations[i])] = true
+	}
+	for _, loc := range f.locations {
+		if key := locationKey(loc); !kept[key] {
+			removeLocation(key)
+		}
+	}
+
+	f.modTime = info.ModTime()
+	f.locations = locations
+	log.Printf("Loaded %d locations from %s", len(locations), f.path)
+	return nil
+}
+
+// current returns the locations of the last successful load
+func (f *locationsFile) current() []location {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.locations
+}
+
+// removeLocation deletes the status and all series of a location that is no
+// longer configured
+func removeLocation(key string) {
+	if station := removeLocationStatus(key); station != "" {
+		removeStation(station)
+		log.Printf("Deleted metrics of station %s, location %s was removed", station, key)
+	}
+}
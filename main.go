@@ -1,17 +1,33 @@
 package main
 
 import (
+	"bytes"
+	"context"
+	"crypto/tls"
 	"encoding/json"
+	"errors"
+	"flag"
 	"fmt"
+	"io"
 	"log"
+	"math"
+	"mime"
+	"net"
 	"net/http"
+	"net/url"
 	"os"
+	"os/signal"
+	"runtime/debug"
 	"strconv"
+	"strings"
+	"sync"
+	"syscall"
 	"time"
 
 	"github.com/joho/godotenv"
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"github.com/prometheus/client_golang/prometheus/push"
 )
 
 // Weather API response structures
@@ -41,10 +57,17 @@ type WeatherResponse struct {
 	Wind       struct {
 		Speed float64 `json:"speed"`
 		Deg   float64 `json:"deg"`
+		Gust  float64 `json:"gust"`
 	} `json:"wind"`
 	Clouds struct {
 		All float64 `json:"all"`
 	} `json:"clouds"`
+	Rain struct {
+		OneHour float64 `json:"1h"`
+	} `json:"rain"`
+	Snow struct {
+		OneHour float64 `json:"1h"`
+	} `json:"snow"`
 	Dt  int64 `json:"dt"`
 	Sys struct {
 		Type    int    `json:"type"`
@@ -57,6 +80,89 @@ type WeatherResponse struct {
 	ID       int    `json:"id"`
 	Name     string `json:"name"`
 	Cod      int    `json:"cod"`
+
+	// Present records which optional fields were in the response, as they
+	// decode to 0 when absent
+	Present map[string]bool `json:"-"`
+	// Completeness is the fraction of completenessFields in the response
+	Completeness float64 `json:"-"`
+}
+
+// completenessFields are the number of fields every current weather response
+// is expected to contain, counted into WeatherResponse.Completeness
+const completenessFields = 12
+
+// UnmarshalJSON decodes the response and records the optional fields present
+func (w *WeatherResponse) UnmarshalJSON(data []byte) error {
+	type plain WeatherResponse
+	if err := json.Unmarshal(data, (*plain)(w)); err != nil {
+		return err
+	}
+	var probe struct {
+		Weather json.RawMessage `json:"weather"`
+		Main    struct {
+			Temp      json.RawMessage `json:"temp"`
+			FeelsLike json.RawMessage `json:"feels_like"`
+			Pressure  json.RawMessage `json:"pressure"`
+			Humidity  json.RawMessage `json:"humidity"`
+			SeaLevel  json.RawMessage `json:"sea_level"`
+			GrndLevel json.RawMessage `json:"grnd_level"`
+		} `json:"main"`
+		Wind struct {
+			Speed json.RawMessage `json:"speed"`
+			Deg   json.RawMessage `json:"deg"`
+			Gust  json.RawMessage `json:"gust"`
+		} `json:"wind"`
+		Clouds struct {
+			All json.RawMessage `json:"all"`
+		} `json:"clouds"`
+		Visibility json.RawMessage `json:"visibility"`
+		Rain       json.RawMessage `json:"rain"`
+		Snow       json.RawMessage `json:"snow"`
+		Dt         json.RawMessage `json:"dt"`
+		Sys        struct {
+			Sunrise json.RawMessage `json:"sunrise"`
+			Sunset  json.RawMessage `json:"sunset"`
+		} `json:"sys"`
+	}
+	if err := json.Unmarshal(data, &probe); err != nil {
+		return err
+	}
+	w.Present = map[string]bool{
+		"sea_level":  jsonPresent(probe.Main.SeaLevel),
+		"grnd_level": jsonPresent(probe.Main.GrndLevel),
+		"gust":       jsonPresent(probe.Wind.Gust),
+		"visibility": jsonPresent(probe.Visibility),
+		"rain":       jsonPresent(probe.Rain),
+		"snow":       jsonPresent(probe.Snow),
+	}
+
+	expected := [completenessFields]json.RawMessage{
+		probe.Main.Temp, probe.Main.FeelsLike, probe.Main.Pressure, probe.Main.Humidity,
+		probe.Wind.Speed, probe.Wind.Deg, probe.Clouds.All, probe.Visibility,
+		probe.Weather, probe.Dt, probe.Sys.Sunrise, probe.Sys.Sunset,
+	}
+	present := 0
+	for _, raw := range expected {
+		// An empty list of conditions is as sparse as a missing one
+		if jsonPresent(raw) && string(raw) != "[]" {
+			present++
+		}
+	}
+	w.Completeness = float64(present) / completenessFields
+	return nil
+}
+
+// jsonPresent reports whether a field was in a JSON object with a value
+// other than null
+func jsonPresent(raw json.RawMessage) bool {
+	return len(raw) > 0 && string(raw) != "null"
+}
+
+// Group API response structure, one current weather entry per city
+type GroupResponse struct {
+	Cnt  int               `json:"cnt"`
+	List []WeatherResponse `json:"list"`
 }
 
 // Air Pollution API response structures
@@ -83,94 +189,288 @@ type AirPollutionResponse struct {
 	} `json:"list"`
 }
 
-// Prometheus metrics
+// Weather metrics whose units depend on UNITS, created by registerUnitMetrics
 var (
-	// Weather metrics
-	owWeatherTemp = prometheus.NewGaugeVec(
+	owWeatherTemp      *prometheus.GaugeVec
+	owWeatherFeelsLike *prometheus.GaugeVec
+	// owWeatherFeelsLikeRate is derived from the feels like history
+	owWeatherFeelsLikeRate *prometheus.GaugeVec
+	owWeatherTempMin       *prometheus.GaugeVec
+	owWeatherTempMax       *prometheus.GaugeVec
+	owWeatherWindSpeed     *prometheus.GaugeVec
+
+	owWeatherTempSmoothed      *prometheus.GaugeVec
+	owWeatherWindSpeedSmoothed *prometheus.GaugeVec
+)
+
+// registerUnitMetrics creates and registers the unit dependent weather metrics.
+// They carry a unit label with the unit system of each location. The help
+// text names the units when all locations share the unit system, pass an
+// empty units for mixed unit systems.
+func registerUnitMetrics(units string) {
+	symbols, ok := unitSymbols[units]
+	if !ok {
+		symbols.temp = "the unit system of the unit label"
+		symbols.speed = symbols.temp
+	}
+	owWeatherTemp = newGaugeVec(
 		prometheus.GaugeOpts{
 			Name: "ow_weather_temp",
-			Help: "Current temperature",
+			Help: "Current temperature in " + symbols.temp,
 		},
-		[]string{"station"},
+		[]string{"station", "unit"},
 	)
-	owWeatherFeelsLike = prometheus.NewGaugeVec(
+	owWeatherFeelsLike = newGaugeVec(
 		prometheus.GaugeOpts{
 			Name: "ow_weather_feels_like",
-			Help: "Feels like temperature",
+			Help: "Feels like temperature in " + symbols.temp,
 		},
-		[]string{"station"},
+		[]string{"station", "unit"},
+	)
+	owWeatherFeelsLikeRate = newGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "ow_weather_feels_like_rate",
+			Help: "Change of the feels like temperature in " + symbols.temp + " per hour",
+		},
+		[]string{"station", "unit"},
 	)
-	owWeatherTempMin = prometheus.NewGaugeVec(
+	owWeatherTempMin = newGaugeVec(
 		prometheus.GaugeOpts{
 			Name: "ow_weather_temp_min",
-			Help: "Minimum temperature",
+			Help: "Minimum temperature in " + symbols.temp,
 		},
-		[]string{"station"},
+		[]string{"station", "unit"},
 	)
-	owWeatherTempMax = prometheus.NewGaugeVec(
+	owWeatherTempMax = newGaugeVec(
 		prometheus.GaugeOpts{
 			Name: "ow_weather_temp_max",
-			Help: "Maximum temperature",
+			Help: "Maximum temperature in " + symbols.temp,
+		},
+		[]string{"station", "unit"},
+	)
+	owWeatherWindSpeed = newGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "ow_weather_wind_speed",
+			Help: "Wind speed in " + symbols.speed,
+		},
+		[]string{"station", "unit"},
+	)
+	owWeatherTempSmoothed = newGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "ow_weather_temp_smoothed",
+			Help: "Exponential moving average of the temperature in " + symbols.temp,
+		},
+		[]string{"station", "unit"},
+	)
+	owWeatherWindSpeedSmoothed = newGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "ow_weather_wind_speed_smoothed",
+			Help: "Exponential moving average of the wind speed in " + symbols.speed,
+		},
+		[]string{"station", "unit"},
+	)
+
+	exporterRegistry.MustRegister(owWeatherTemp)
+	exporterRegistry.MustRegister(owWeatherFeelsLike)
+	exporterRegistry.MustRegister(owWeatherFeelsLikeRate)
+	exporterRegistry.MustRegister(owWeatherTempMin)
+	exporterRegistry.MustRegister(owWeatherTempMax)
+	exporterRegistry.MustRegister(owWeatherWindSpeed)
+	exporterRegistry.MustRegister(owWeatherTempSmoothed)
+	exporterRegistry.MustRegister(owWeatherWindSpeedSmoothed)
+}
+
+// exporterRegistry holds the exporter's own metrics. Unless
+// DISABLE_GO_COLLECTORS is set, they are served together with the Go and
+// process metrics of the default registry.
+var exporterRegistry = prometheus.NewRegistry()
+
+// Prometheus metrics
+var (
+	// Weather metrics
+	owWeatherTempCelsius = newGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "ow_weather_temp_celsius",
+			Help: "Current temperature in °C",
+		},
+		[]string{"station"},
+	)
+	owWeatherTempFahrenheit = newGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "ow_weather_temp_fahrenheit",
+			Help: "Current temperature in °F",
 		},
 		[]string{"station"},
 	)
-	owWeatherPressure = prometheus.NewGaugeVec(
+	owWeatherPressure = newGaugeVec(
 		prometheus.GaugeOpts{
 			Name: "ow_weather_pressure",
 			Help: "Atmospheric pressure in hPa",
 		},
 		[]string{"station"},
 	)
-	owWeatherHumidity = prometheus.NewGaugeVec(
+	owWeatherPressureInHg = newGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "ow_weather_pressure_inhg",
+			Help: "Atmospheric pressure in inches of mercury",
+		},
+		[]string{"station"},
+	)
+	owWeatherPressureTrend = newGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "ow_weather_pressure_trend",
+			Help: "Atmospheric pressure trend in hPa per hour",
+		},
+		[]string{"station"},
+	)
+	owWeatherHumidity = newGaugeVec(
 		prometheus.GaugeOpts{
 			Name: "ow_weather_humidity",
 			Help: "Humidity percentage",
 		},
 		[]string{"station"},
 	)
-	owWeatherSeaLevel = prometheus.NewGaugeVec(
+	owWeatherHighHumidity = newGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "ow_weather_high_humidity",
+			Help: "Whether the humidity exceeds HUMIDITY_THRESHOLD (1 = above)",
+		},
+		[]string{"station"},
+	)
+	owWeatherAbsoluteHumidity = newGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "ow_weather_absolute_humidity",
+			Help: "Absolute humidity in g/m³, computed from the temperature and relative humidity",
+		},
+		[]string{"station"},
+	)
+	owWeatherSeaLevel = newGaugeVec(
 		prometheus.GaugeOpts{
 			Name: "ow_weather_sea_level",
 			Help: "Sea level pressure in hPa",
 		},
 		[]string{"station"},
 	)
-	owWeatherGrndLevel = prometheus.NewGaugeVec(
+	owWeatherGrndLevel = newGaugeVec(
 		prometheus.GaugeOpts{
 			Name: "ow_weather_grnd_level",
 			Help: "Ground level pressure in hPa",
 		},
 		[]string{"station"},
 	)
-	owWeatherVisibility = prometheus.NewGaugeVec(
+	owWeatherVisibility = newGaugeVec(
 		prometheus.GaugeOpts{
 			Name: "ow_weather_visibility",
 			Help: "Visibility in meters",
 		},
 		[]string{"station"},
 	)
-	owWeatherWindSpeed = prometheus.NewGaugeVec(
+	owWeatherWindDeg = newGaugeVec(
 		prometheus.GaugeOpts{
-			Name: "ow_weather_wind_speed",
-			Help: "Wind speed",
+			Name: "ow_weather_wind_deg",
+			Help: "Wind direction in degrees",
 		},
 		[]string{"station"},
 	)
-	owWeatherWindDeg = prometheus.NewGaugeVec(
+	owWeatherWindOffset = newGaugeVec(
 		prometheus.GaugeOpts{
-			Name: "ow_weather_wind_deg",
-			Help: "Wind direction in degrees",
+			Name: "ow_weather_wind_offset_degrees",
+			Help: "Angle between the wind direction and TARGET_BEARING, from 0 to 180 degrees",
+		},
+		[]string{"station"},
+	)
+	owWeatherWindDegStddev = newGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "ow_weather_wind_deg_stddev",
+			Help: "Circular standard deviation of the wind direction over recent readings in degrees",
+		},
+		[]string{"station"},
+	)
+	owWeatherWindBeaufort = newGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "ow_weather_wind_beaufort",
+			Help: "Wind force on the Beaufort scale (0-12)",
+		},
+		[]string{"station"},
+	)
+	owWeatherWindBeaufortInfo = newGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "ow_weather_wind_beaufort_info",
+			Help: "Description of the current Beaufort wind force (always 1)",
+		},
+		[]string{"station", "description"},
+	)
+	owWeatherSecondsToSunrise = newGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "ow_weather_seconds_to_sunrise",
+			Help: "Seconds until the next sunrise",
+		},
+		[]string{"station"},
+	)
+	owWeatherSecondsToSunset = newGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "ow_weather_seconds_to_sunset",
+			Help: "Seconds until the next sunset",
+		},
+		[]string{"station"},
+	)
+	owWeatherSunTimesInfo = newGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "ow_weather_sun_times_info",
+			Help: "Sunrise and sunset as HH:MM in the local time of the station (always 1)",
+		},
+		[]string{"station", "sunrise", "sunset"},
+	)
+	owWeatherComfortInfo = newGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "ow_weather_comfort_info",
+			Help: "Comfort band of the current feels like temperature (always 1)",
+		},
+		[]string{"station", "band"},
+	)
+	owWeatherRainAccumulated = newGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "ow_weather_rain_accumulated_mm",
+			Help: "Rain since local midnight in mm, integrated from the 1h rain volume",
+		},
+		[]string{"station"},
+	)
+	owWeatherFieldsPresent = newGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "ow_weather_fields_present",
+			Help: "Whether an optional field was in the last weather response (1) or not (0), labeled by field",
+		},
+		[]string{"station", "field"},
+	)
+	owWeatherCompleteness = newGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "ow_weather_completeness_ratio",
+			Help: "Fraction of the expected fields that were in the last weather response",
+		},
+		[]string{"station"},
+	)
+	owWeatherIsPrecipitating = newGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "ow_weather_is_precipitating",
+			Help: "Whether it is raining, drizzling, snowing, or thundering (1) or not (0)",
 		},
 		[]string{"station"},
 	)
-	owWeatherClouds = prometheus.NewGaugeVec(
+	owWeatherClouds = newGaugeVec(
 		prometheus.GaugeOpts{
 			Name: "ow_weather_clouds",
 			Help: "Cloud coverage percentage",
 		},
 		[]string{"station"},
 	)
-	owWeatherCondition = prometheus.NewGaugeVec(
+	owWeatherUnitSanity = newGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "ow_weather_unit_sanity",
+			Help: "Whether the temperature is plausible for the configured units (1 = ok, 0 = suspicious)",
+		},
+		[]string{"station"},
+	)
+	owWeatherCondition = newGaugeVec(
 		prometheus.GaugeOpts{
 			Name: "ow_weather_condition",
 			Help: "Weather condition ID",
@@ -178,230 +478,2125 @@ var (
 		[]string{"station", "main", "description"},
 	)
 
+	owWeatherConditionCount = newGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "ow_weather_condition_count",
+			Help: "Number of weather conditions currently reported",
+		},
+		[]string{"station"},
+	)
+
+	owWeatherIconInfo = newGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "ow_weather_icon_info",
+			Help: "OpenWeather icon code and matching emoji of the current condition (always 1)",
+		},
+		[]string{"station", "icon", "emoji"},
+	)
+	owWeatherMissingCondition = newCounterVec(
+		prometheus.CounterOpts{
+			Name: "ow_weather_missing_condition_total",
+			Help: "Total number of weather responses without a weather condition",
+		},
+		[]string{"station"},
+	)
+
+	owStationInfo = newGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "ow_station_info",
+			Help: "Station information (always 1)",
+		},
+		[]string{"station", "name", "lat", "lon", "base"},
+	)
+
 	// Air pollution metrics
-	owAirPollutionAQI = prometheus.NewGaugeVec(
+	owAirPollutionAQI = newGaugeVec(
 		prometheus.GaugeOpts{
 			Name: "ow_air_pollution_aqi",
 			Help: "Air Quality Index (1-5)",
 		},
+		[]string{"station", "source"},
+	)
+	owAirPollutionAQIDelta = newGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "ow_air_pollution_aqi_delta",
+			Help: "Change in Air Quality Index since the previous scrape",
+		},
+		[]string{"station"},
+	)
+	owAirPollutionObservationTime = newGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "ow_air_pollution_observation_timestamp_seconds",
+			Help: "Time of the air pollution observation since unix epoch in seconds",
+		},
+		[]string{"station"},
+	)
+	owAirPollutionListLength = newGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "ow_air_pollution_list_length",
+			Help: "Number of entries in the most recent air pollution response, of which only the first is exported",
+		},
 		[]string{"station"},
 	)
-	owAirPollutionCO = prometheus.NewGaugeVec(
+	owAirPollutionSubIndex = newGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "ow_air_pollution_subindex",
+			Help: "Air Quality Index (1-5) of a single pollutant",
+		},
+		[]string{"station", "source", "pollutant"},
+	)
+	owAirPollutionCO = newGaugeVec(
 		prometheus.GaugeOpts{
 			Name: "ow_air_pollution_co",
 			Help: "Carbon monoxide concentration in μg/m³",
 		},
-		[]string{"station"},
+		[]string{"station", "source"},
 	)
-	owAirPollutionNO = prometheus.NewGaugeVec(
+	owAirPollutionNO = newGaugeVec(
 		prometheus.GaugeOpts{
 			Name: "ow_air_pollution_no",
 			Help: "Nitrogen monoxide concentration in μg/m³",
 		},
-		[]string{"station"},
+		[]string{"station", "source"},
 	)
-	owAirPollutionNO2 = prometheus.NewGaugeVec(
+	owAirPollutionNO2 = newGaugeVec(
 		prometheus.GaugeOpts{
 			Name: "ow_air_pollution_no2",
 			Help: "Nitrogen dioxide concentration in μg/m³",
 		},
-		[]string{"station"},
+		[]string{"station", "source"},
 	)
-	owAirPollutionO3 = prometheus.NewGaugeVec(
+	owAirPollutionO3 = newGaugeVec(
 		prometheus.GaugeOpts{
 			Name: "ow_air_pollution_o3",
 			Help: "Ozone concentration in μg/m³",
 		},
-		[]string{"station"},
+		[]string{"station", "source"},
 	)
-	owAirPollutionSO2 = prometheus.NewGaugeVec(
+	owAirPollutionSO2 = newGaugeVec(
 		prometheus.GaugeOpts{
 			Name: "ow_air_pollution_so2",
 			Help: "Sulphur dioxide concentration in μg/m³",
 		},
-		[]string{"station"},
+		[]string{"station", "source"},
 	)
-	owAirPollutionPM25 = prometheus.NewGaugeVec(
+	owAirPollutionPM25 = newGaugeVec(
 		prometheus.GaugeOpts{
 			Name: "ow_air_pollution_pm2_5",
 			Help: "PM2.5 concentration in μg/m³",
 		},
-		[]string{"station"},
+		[]string{"station", "source"},
 	)
-	owAirPollutionPM10 = prometheus.NewGaugeVec(
+	owAirPollutionPM10 = newGaugeVec(
 		prometheus.GaugeOpts{
 			Name: "ow_air_pollution_pm10",
 			Help: "PM10 concentration in μg/m³",
 		},
-		[]string{"station"},
+		[]string{"station", "source"},
 	)
-	owAirPollutionNH3 = prometheus.NewGaugeVec(
+	owAirPollutionNH3 = newGaugeVec(
 		prometheus.GaugeOpts{
 			Name: "ow_air_pollution_nh3",
 			Help: "Ammonia concentration in μg/m³",
 		},
-		[]string{"station"},
+		[]string{"station", "source"},
 	)
 )
 
-func init() {
-	// Register weather metrics
-	prometheus.MustRegister(owWeatherTemp)
-	prometheus.MustRegister(owWeatherFeelsLike)
-	prometheus.MustRegister(owWeatherTempMin)
-	prometheus.MustRegister(owWeatherTempMax)
-	prometheus.MustRegister(owWeatherPressure)
-	prometheus.MustRegister(owWeatherHumidity)
-	prometheus.MustRegister(owWeatherSeaLevel)
-	prometheus.MustRegister(owWeatherGrndLevel)
-	prometheus.MustRegister(owWeatherVisibility)
-	prometheus.MustRegister(owWeatherWindSpeed)
-	prometheus.MustRegister(owWeatherWindDeg)
-	prometheus.MustRegister(owWeatherClouds)
-	prometheus.MustRegister(owWeatherCondition)
+// Exporter metrics
+var (
+	// owScrapeDuration is a histogram or, with LATENCY_METRIC=summary, a
+	// summary of the API request durations
+	owScrapeDuration prometheus.ObserverVec
 
-	// Register air pollution metrics
-	prometheus.MustRegister(owAirPollutionAQI)
-	prometheus.MustRegister(owAirPollutionCO)
-	prometheus.MustRegister(owAirPollutionNO)
-	prometheus.MustRegister(owAirPollutionNO2)
-	prometheus.MustRegister(owAirPollutionO3)
-	prometheus.MustRegister(owAirPollutionSO2)
-	prometheus.MustRegister(owAirPollutionPM25)
-	prometheus.MustRegister(owAirPollutionPM10)
-	prometheus.MustRegister(owAirPollutionNH3)
-}
-
-func fetchWeatherData(url string) (string, error) {
-	resp, err := http.Get(url)
-	if err != nil {
-		return "", fmt.Errorf("failed to fetch weather data: %w", err)
-	}
-	defer resp.Body.Close()
+	owScrapeErrors = newCounterVec(
+		prometheus.CounterOpts{
+			Name: "ow_scrape_errors_total",
+			Help: "Total number of failed OpenWeather API requests by reason (http, status, decode)",
+		},
+		[]string{"endpoint", "reason"},
+	)
+	owConfigErrors = prometheus.NewGauge(
+		prometheus.GaugeOpts{
+			Name: "ow_config_errors",
+			Help: "Number of invalid configuration values ignored at startup because STRICT_STARTUP is false",
+		},
+	)
+	owAPIRequests = newCounterVec(
+		prometheus.CounterOpts{
+			Name: "ow_api_requests_total",
+			Help: "Total number of OpenWeather API requests by host, method and status code",
+		},
+		[]string{"host", "method", "code"},
+	)
+	owAPIKeyValid = prometheus.NewGauge(
+		prometheus.GaugeOpts{
+			Name: "ow_api_key_valid",
+			Help: "Whether the API key was accepted by OpenWeather, 0 after a 401 response until the next successful request",
+		},
+	)
+	owAPIResponseBytes = newGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "ow_api_response_bytes",
+			Help: "Size of the most recent OpenWeather API response body in bytes",
+		},
+		[]string{"endpoint"},
+	)
+	owExporterStartTime = prometheus.NewGauge(
+		prometheus.GaugeOpts{
+			Name: "ow_exporter_start_time_seconds",
+			Help: "Start time of the exporter since unix epoch in seconds",
+		},
+	)
+	owExporterPanics = prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Name: "ow_exporter_panics_total",
+			Help: "Total number of panics recovered while updating metrics",
+		},
+	)
+	owExporterConfigInfo = newGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "ow_exporter_config_info",
+			Help: "Effective exporter configuration in the labels, without secrets (always 1)",
+		},
+		[]string{
+			"units", "scrape_interval", "tier", "mode", "latency_metric",
+			"forecast_enabled", "daily16_enabled", "solar_enabled", "overview_enabled", "air_pollution_fallback_enabled",
+		},
+	)
+	owScrapeInterval = prometheus.NewGauge(
+		prometheus.GaugeOpts{
+			Name: "ow_exporter_scrape_interval_seconds",
+			Help: "Configured interval between OpenWeather updates in seconds",
+		},
+	)
+	owActiveStations = prometheus.NewGauge(
+		prometheus.GaugeOpts{
+			Name: "ow_active_stations",
+			Help: "Number of stations successfully updated in the most recent cycle",
+		},
+	)
+)
 
-	if resp.StatusCode != http.StatusOK {
-		return "", fmt.Errorf("weather API returned status code: %d", resp.StatusCode)
+// newScrapeDurationHistogram creates the API request duration histogram with
+// the given classic buckets, optionally with native histogram buckets in
+// addition to them.
+func newScrapeDurationHistogram(buckets []float64, native bool) *prometheus.HistogramVec {
+	opts := prometheus.HistogramOpts{
+		Name:    "ow_scrape_duration_seconds",
+		Help:    "Duration of OpenWeather API requests in seconds",
+		Buckets: buckets,
 	}
+	if native {
+		opts.NativeHistogramBucketFactor = 1.1
+		opts.NativeHistogramMaxBucketNumber = 100
+		opts.NativeHistogramMinResetDuration = time.Hour
+	}
+	return newHistogramVec(opts, []string{"endpoint"})
+}
 
-	var weather WeatherResponse
-	if err := json.NewDecoder(resp.Body).Decode(&weather); err != nil {
-		return "", fmt.Errorf("failed to decode weather response: %w", err)
+// parseBuckets parses a comma separated list of histogram bucket upper bounds
+// in seconds, e.g. "0.1,0.25,0.5,1,2.5". The bounds must be strictly ascending.
+func parseBuckets(value string) ([]float64, error) {
+	var buckets []float64
+	for _, entry := range strings.Split(value, ",") {
+		bound, err := strconv.ParseFloat(strings.TrimSpace(entry), 64)
+		if err != nil || math.IsNaN(bound) || bound <= 0 || math.IsInf(bound, 0) {
+			return nil, fmt.Errorf("bucket %q must be a positive number of seconds", entry)
+		}
+		if n := len(buckets); n > 0 && bound <= buckets[n-1] {
+			return nil, fmt.Errorf("buckets must be in ascending order, %v is not greater than %v", bound, buckets[n-1])
+		}
+		buckets = append(buckets, bound)
 	}
+	return buckets, nil
+}
 
-	station := strconv.Itoa(weather.ID)
+// newLatencySummary creates the API request duration summary with client-side
+// quantiles, an alternative to the histogram for single instances.
+func newLatencySummary() *prometheus.SummaryVec {
+	return newSummaryVec(
+		prometheus.SummaryOpts{
+			Name:       "ow_api_latency_summary",
+			Help:       "Duration of OpenWeather API requests in seconds",
+			Objectives: map[float64]float64{0.5: 0.05, 0.9: 0.01, 0.99: 0.001},
+		},
+		[]string{"endpoint"},
+	)
+}
 
-	// Update weather metrics
-	owWeatherTemp.WithLabelValues(station).Set(weather.Main.Temp)
-	owWeatherFeelsLike.WithLabelValues(station).Set(weather.Main.FeelsLike)
-	owWeatherTempMin.WithLabelValues(station).Set(weather.Main.TempMin)
-	owWeatherTempMax.WithLabelValues(station).Set(weather.Main.TempMax)
-	owWeatherPressure.WithLabelValues(station).Set(weather.Main.Pressure)
-	owWeatherHumidity.WithLabelValues(station).Set(weather.Main.Humidity)
-	owWeatherSeaLevel.WithLabelValues(station).Set(weather.Main.SeaLevel)
-	owWeatherGrndLevel.WithLabelValues(station).Set(weather.Main.GrndLevel)
-	owWeatherVisibility.WithLabelValues(station).Set(weather.Visibility)
-	owWeatherWindSpeed.WithLabelValues(station).Set(weather.Wind.Speed)
-	owWeatherWindDeg.WithLabelValues(station).Set(weather.Wind.Deg)
-	owWeatherClouds.WithLabelValues(station).Set(weather.Clouds.All)
-
-	// Update weather condition (set to 1 to indicate active, 0 would be inactive)
-	if len(weather.Weather) > 0 {
-		owWeatherCondition.WithLabelValues(station, weather.Weather[0].Main, weather.Weather[0].Description).Set(1)
-	}
+// countScrapeError counts a failed request. The reason is "http" for network
+// errors, "status" for non-200 responses, and "decode" for unexpected bodies.
+func countScrapeError(endpoint, reason string) {
+	owScrapeErrors.WithLabelValues(endpoint, reason).Inc()
+}
 
-	return station, nil
+func observeScrapeDuration(endpoint string, start time.Time) {
+	owScrapeDuration.WithLabelValues(endpoint).Observe(time.Since(start).Seconds())
 }
 
-func fetchAirPollutionData(url string, station string) error {
-	resp, err := http.Get(url)
-	if err != nil {
-		return fmt.Errorf("failed to fetch air pollution data: %w", err)
-	}
-	defer resp.Body.Close()
+func init() {
+	// Register weather metrics
+	exporterRegistry.MustRegister(owWeatherTempCelsius)
+	exporterRegistry.MustRegister(owWeatherTempFahrenheit)
+	exporterRegistry.MustRegister(owWeatherPressure)
+	exporterRegistry.MustRegister(owWeatherPressureInHg)
+	exporterRegistry.MustRegister(owWeatherPressureTrend)
+	exporterRegistry.MustRegister(owWeatherHumidity)
+	exporterRegistry.MustRegister(owWeatherHighHumidity)
+	exporterRegistry.MustRegister(owWeatherAbsoluteHumidity)
+	exporterRegistry.MustRegister(owWeatherSeaLevel)
+	exporterRegistry.MustRegister(owWeatherGrndLevel)
+	exporterRegistry.MustRegister(owWeatherVisibility)
+	exporterRegistry.MustRegister(owWeatherWindDeg)
+	exporterRegistry.MustRegister(owWeatherWindDegStddev)
+	exporterRegistry.MustRegister(owWeatherWindOffset)
+	exporterRegistry.MustRegister(owWeatherWindBeaufort)
+	exporterRegistry.MustRegister(owWeatherWindBeaufortInfo)
+	exporterRegistry.MustRegister(owWeatherSecondsToSunrise)
+	exporterRegistry.MustRegister(owWeatherSecondsToSunset)
+	exporterRegistry.MustRegister(owWeatherSunTimesInfo)
+	exporterRegistry.MustRegister(owWeatherComfortInfo)
+	exporterRegistry.MustRegister(owWeatherClouds)
+	exporterRegistry.MustRegister(owWeatherRainAccumulated)
+	exporterRegistry.MustRegister(owWeatherIsPrecipitating)
+	exporterRegistry.MustRegister(owWeatherFieldsPresent)
+	exporterRegistry.MustRegister(owWeatherCompleteness)
+	exporterRegistry.MustRegister(owWeatherUnitSanity)
+	exporterRegistry.MustRegister(owWeatherCondition)
+	exporterRegistry.MustRegister(owWeatherConditionCount)
+	exporterRegistry.MustRegister(owWeatherMissingCondition)
+	exporterRegistry.MustRegister(owWeatherIconInfo)
+	exporterRegistry.MustRegister(owStationInfo)
 
-	if resp.StatusCode != http.StatusOK {
-		return fmt.Errorf("air pollution API returned status code: %d", resp.StatusCode)
-	}
+	// Register air pollution metrics
+	exporterRegistry.MustRegister(owAirPollutionAQI)
+	exporterRegistry.MustRegister(owAirPollutionAQIDelta)
+	exporterRegistry.MustRegister(owAirPollutionObservationTime)
+	exporterRegistry.MustRegister(owAirPollutionListLength)
+	exporterRegistry.MustRegister(owAirPollutionSubIndex)
+	exporterRegistry.MustRegister(owAirPollutionCO)
+	exporterRegistry.MustRegister(owAirPollutionNO)
+	exporterRegistry.MustRegister(owAirPollutionNO2)
+	exporterRegistry.MustRegister(owAirPollutionO3)
+	exporterRegistry.MustRegister(owAirPollutionSO2)
+	exporterRegistry.MustRegister(owAirPollutionPM25)
+	exporterRegistry.MustRegister(owAirPollutionPM10)
+	exporterRegistry.MustRegister(owAirPollutionNH3)
 
-	var pollution AirPollutionResponse
-	if err := json.NewDecoder(resp.Body).Decode(&pollution); err != nil {
-		return fmt.Errorf("failed to decode air pollution response: %w", err)
+	// Register exporter metrics
+	exporterRegistry.MustRegister(owExporterStartTime)
+	exporterRegistry.MustRegister(owExporterPanics)
+	exporterRegistry.MustRegister(owScrapeInterval)
+	exporterRegistry.MustRegister(owExporterConfigInfo)
+	exporterRegistry.MustRegister(owActiveStations)
+	exporterRegistry.MustRegister(owScrapeErrors)
+	exporterRegistry.MustRegister(owConfigErrors)
+	exporterRegistry.MustRegister(owAPIRequests)
+	exporterRegistry.MustRegister(owAPIKeyValid)
+	exporterRegistry.MustRegister(owAPIResponseBytes)
+}
+
+// seriesDeleter is a metric vector whose series can be deleted by label
+type seriesDeleter interface {
+	DeletePartialMatch(prometheus.Labels) int
+}
+
+// airPollutionMetrics returns all air pollution metrics labeled by station
+func airPollutionMetrics() []seriesDeleter {
+	return []seriesDeleter{
+		owAirPollutionAQI, owAirPollutionAQIDelta, owAirPollutionObservationTime, owAirPollutionListLength,
+		owAirPollutionSubIndex,
+		owAirPollutionCO, owAirPollutionNO, owAirPollutionNO2, owAirPollutionO3,
+		owAirPollutionSO2, owAirPollutionPM25, owAirPollutionPM10, owAirPollutionNH3,
 	}
+}
 
-	// Update air pollution metrics
-	if len(pollution.List) > 0 {
-		data := pollution.List[0]
-		owAirPollutionAQI.WithLabelValues(station).Set(float64(data.Main.AQI))
-		owAirPollutionCO.WithLabelValues(station).Set(data.Components.CO)
-		owAirPollutionNO.WithLabelValues(station).Set(data.Components.NO)
-		owAirPollutionNO2.WithLabelValues(station).Set(data.Components.NO2)
-		owAirPollutionO3.WithLabelValues(station).Set(data.Components.O3)
-		owAirPollutionSO2.WithLabelValues(station).Set(data.Components.SO2)
-		owAirPollutionPM25.WithLabelValues(station).Set(data.Components.PM25)
-		owAirPollutionPM10.WithLabelValues(station).Set(data.Components.PM10)
-		owAirPollutionNH3.WithLabelValues(station).Set(data.Components.NH3)
+// airPollutionSources are the values of the source label, which names the
+// provider of the air pollution values
+var airPollutionSources = []string{"openweather", "fallback"}
+
+// airPollutionSourceMetrics returns the air pollution metrics labeled by source
+func airPollutionSourceMetrics() []seriesDeleter {
+	return []seriesDeleter{
+		owAirPollutionAQI, owAirPollutionSubIndex,
+		owAirPollutionCO, owAirPollutionNO, owAirPollutionNO2, owAirPollutionO3,
+		owAirPollutionSO2, owAirPollutionPM25, owAirPollutionPM10, owAirPollutionNH3,
 	}
+}
 
-	return nil
+// stationMetrics returns all metrics labeled by station
+func stationMetrics() []seriesDeleter {
+	return append([]seriesDeleter{
+		owWeatherTemp, owWeatherFeelsLike, owWeatherFeelsLikeRate, owWeatherTempMin, owWeatherTempMax,
+		owWeatherWindSpeed, owWeatherTempSmoothed, owWeatherWindSpeedSmoothed,
+		owWeatherTempCelsius, owWeatherTempFahrenheit, owWeatherPressure, owWeatherPressureInHg,
+		owWeatherPressureTrend, owWeatherHumidity, owWeatherHighHumidity, owWeatherAbsoluteHumidity, owWeatherSeaLevel,
+		owWeatherGrndLevel, owWeatherVisibility, owWeatherWindDeg,
+		owWeatherWindDegStddev, owWeatherWindOffset, owWeatherWindBeaufort, owWeatherWindBeaufortInfo,
+		owWeatherSecondsToSunrise, owWeatherSecondsToSunset, owWeatherSunTimesInfo,
+		owWeatherComfortInfo, owWeatherClouds, owWeatherRainAccumulated, owWeatherIsPrecipitating, owWeatherUnitSanity, owWeatherCondition,
+		owWeatherConditionCount, owWeatherMissingCondition, owWeatherFieldsPresent, owWeatherCompleteness,
+		owWeatherIdenticalReadings, owWeatherIconInfo,
+		owStationInfo,
+		owSolarGHI, owSolarDNI, owSolarDHI,
+		owForecastPrecipitationProbability, owForecastTempError,
+		owForecast16TempDay, owForecast16TempNight, owForecast16TempMin, owForecast16TempMax,
+		owWeatherOverviewInfo, owWeatherScore, owScrapeSuccessRatio,
+	}, airPollutionMetrics()...)
 }
 
-func updateMetrics(weatherURL, pollutionURL string) {
-	station, err := fetchWeatherData(weatherURL)
-	if err != nil {
-		log.Printf("Error fetching weather data: %v", err)
-		return
-	}
+// apiBaseURL is the scheme and host of the OpenWeather API, overridable with
+// OPENWEATHER_BASE_URL to go through a cache or proxy
+var apiBaseURL = "https://api.openweathermap.org"
 
-	if err := fetchAirPollutionData(pollutionURL, station); err != nil {
-		log.Printf("Error fetching air pollution data: %v", err)
-	}
+// proBaseURL is the scheme and host of products that OpenWeather only serves
+// to paid subscriptions, such as the 16 day daily forecast. It is
+// pro.openweathermap.org with OPENWEATHER_TIER=pro.
+var proBaseURL = apiBaseURL
+
+// tierBaseURLs are the hosts of the pro products per OPENWEATHER_TIER
+var tierBaseURLs = map[string]string{
+	"free": "https://api.openweathermap.org",
+	"pro":  "https://pro.openweathermap.org",
 }
 
-func main() {
-	// Load environment variables from .env if it exists
-	if err := godotenv.Load(); err != nil {
-		log.Printf("Warning: .env file not found, using system environment variables: %v", err)
-	}
+// humidityThreshold is the humidity percentage above which
+// ow_weather_high_humidity is 1
+var humidityThreshold = 70.0
 
-	latitude := os.Getenv("LATITUDE")
-	longitude := os.Getenv("LONGITUDE")
-	units := os.Getenv("UNITS")
-	if units == "" {
-		units = "standard"
-	}
-	if units != "standard" && units != "imperial" && units != "metric" {
-		log.Fatal("UNITS must be either standard, imperial, or metric")
-	}
-	apiKey := os.Getenv("OPENWEATHER_API_KEY")
-	exporterPort := os.Getenv("EXPORTER_PORT")
+// airPollutionPath is the path of the air pollution endpoint below
+// apiBaseURL, overridable with AIR_POLLUTION_PATH
+var airPollutionPath = "/data/2.5/air_pollution"
 
-	if latitude == "" || longitude == "" || apiKey == "" {
-		log.Fatal("LATITUDE, LONGITUDE, and OPENWEATHER_API_KEY environment variables must be set")
-	}
+// airPollutionFallbackURL is the AIR_POLLUTION_FALLBACK_URL template queried
+// when OpenWeather has no air pollution data for a location, empty if unset
+var airPollutionFallbackURL string
 
-	if exporterPort == "" {
-		exporterPort = "8080"
+// otlpExporter pushes the weather and air pollution metrics to OTLP_ENDPOINT
+type otlpExporter interface {
+	push(ctx context.Context) error
+	shutdown(ctx context.Context) error
+}
+
+// newOTLPExporter creates the OTLP exporter. It is only set in binaries built
+// with the otlp tag, see otlp.go, so the OpenTelemetry SDK is not linked
+// into the default binary.
+var newOTLPExporter func(ctx context.Context, endpoint string, gatherer prometheus.Gatherer) (otlpExporter, error)
+
+// units is the default unit system from UNITS. Locations may override it.
+var units = "standard"
+
+// metricPrecision is the number of decimal places values are rounded to, or -1 for no rounding
+var metricPrecision = -1
+
+// roundValue rounds v to the configured metric precision
+func roundValue(v float64) float64 {
+	if metricPrecision < 0 {
+		return v
 	}
+	scale := math.Pow(10, float64(metricPrecision))
+	return math.Round(v*scale) / scale
+}
 
-	currentWeatherURL := fmt.Sprintf("https://api.openweathermap.org/data/2.5/weather?lat=%s&lon=%s&appid=%s&units=%s", latitude, longitude, apiKey, units)
-	pollutionURL := fmt.Sprintf("https://api.openweathermap.org/data/2.5/air_pollution?lat=%s&lon=%s&appid=%s", latitude, longitude, apiKey)
+// validUnits reports whether units is a unit system supported by OpenWeather
+func validUnits(units string) bool {
+	return units == "standard" || units == "metric" || units == "imperial"
+}
 
-	// Initial fetch
-	updateMetrics(currentWeatherURL, pollutionURL)
+// unitSymbols are the temperature and speed units of each unit system
+var unitSymbols = map[string]struct{ temp, speed string }{
+	"standard": {temp: "K", speed: "m/s"},
+	"metric":   {temp: "°C", speed: "m/s"},
+	"imperial": {temp: "°F", speed: "mph"},
+}
 
-	// Update metrics every 5 minutes
-	go func() {
-		// Update metrics every 5 minutes
-		// 2 API calls per tick, 576 calls per day, below the 1000 limit for the free tier
-		ticker := time.NewTicker(5 * time.Minute)
-		defer ticker.Stop()
-		for range ticker.C {
-			updateMetrics(currentWeatherURL, pollutionURL)
-		}
-	}()
+// skipZeroOptional drops the series of optional fields that are missing from
+// the response instead of reporting them as zero
+var skipZeroOptional bool
 
-	// Set up HTTP server for metrics endpoint
-	http.Handle("/metrics", promhttp.Handler())
+// setOptionalGauge sets a field that OpenWeather does not always return. Zero
+// means the field was absent, so the series is deleted when skipZeroOptional
+// is enabled. Fields where zero is a valid reading must not use this.
+func setOptionalGauge(g *prometheus.GaugeVec, station string, value float64) {
+	if skipZeroOptional && value == 0 {
+		g.DeleteLabelValues(station)
+		return
+	}
+	g.WithLabelValues(station).Set(roundValue(value))
+}
+
+// plausibleTemps is the range of plausible surface temperatures for each unit system
+var plausibleTemps = map[string]struct{ min, max float64 }{
+	"standard": {min: 183, max: 333},
+	"metric":   {min: -90, max: 60},
+	"imperial": {min: -130, max: 140},
+}
+
+// temperaturePlausible reports whether temp is a realistic surface temperature
+// in the given unit system. A Kelvin value decoded as Celsius or Fahrenheit is
+// far outside the expected range, which catches a missing units parameter.
+func temperaturePlausible(temp float64, units string) bool {
+	r, ok := plausibleTemps[units]
+	if !ok {
+		return true
+	}
+	return temp >= r.min && temp <= r.max
+}
+
+// Histories backing the derived metrics
+var (
+	pressureHistory  = newStationHistory()
+	windHistory      = newStationHistory()
+	aqiHistory       = newStationHistory()
+	feelsLikeHistory = newStationHistory()
+	rainToday        = newDailyAccumulator()
+
+	tempAverage      = newMovingAverage()
+	windSpeedAverage = newMovingAverage()
+)
+
+// targetBearing is the TARGET_BEARING in degrees that the wind direction is
+// compared with, or negative if unset
+var targetBearing = -1.0
+
+// smoothingAlpha is the weight of new values in the smoothed metrics, or 0 to
+// disable smoothing
+var smoothingAlpha float64
+
+// pressureTrendMinSamples is the number of readings needed before a trend is reported
+const pressureTrendMinSamples = 3
+
+// updatePressureTrend records a pressure reading for the station and sets the
+// trend metric once enough history has been collected.
+func updatePressureTrend(station string, observed time.Time, pressure float64) {
+	history := pressureHistory.Add(station, observed, pressure)
+	if len(history) < pressureTrendMinSamples {
+		return
+	}
+	oldest, newest := history[0], history[len(history)-1]
+	hours := newest.Time.Sub(oldest.Time).Hours()
+	owWeatherPressureTrend.WithLabelValues(station).Set((newest.Value - oldest.Value) / hours)
+}
+
+// updateFeelsLikeRate records a feels like temperature for the station and
+// sets its change per hour over the kept history once two readings exist.
+// The history is kept per units, so readings in different units, e.g. of
+// locations sharing the station or after a reload, are never compared.
+func updateFeelsLikeRate(station, units string, observed time.Time, feelsLike float64) {
+	history := feelsLikeHistory.Add(station+"/"+units, observed, feelsLike)
+	if len(history) < 2 {
+		return
+	}
+	oldest, newest := history[0], history[len(history)-1]
+	hours := newest.Time.Sub(oldest.Time).Hours()
+	owWeatherFeelsLikeRate.WithLabelValues(station, units).Set(roundValue((newest.Value - oldest.Value) / hours))
+}
+
+// windStddevMinSamples is the number of readings needed before a deviation is reported
+const windStddevMinSamples = 3
+
+// angularDifference returns the smallest angle between two directions in
+// degrees, from 0 to 180, so 350° and 10° are 20° apart
+func angularDifference(a, b float64) float64 {
+	diff := math.Mod(math.Abs(a-b), 360)
+	if diff > 180 {
+		diff = 360 - diff
+	}
+	return diff
+}
+
+// circularStddev returns the circular standard deviation of angles in degrees,
+// so that 350° and 10° are treated as 20° apart rather than 340°.
+func circularStddev(degrees []float64) float64 {
+	var sinSum, cosSum float64
+	for _, deg := range degrees {
+		rad := deg * math.Pi / 180
+		sinSum += math.Sin(rad)
+		cosSum += math.Cos(rad)
+	}
+	n := float64(len(degrees))
+	r := math.Hypot(sinSum/n, cosSum/n)
+	if r >= 1 {
+		return 0
+	}
+	return math.Sqrt(-2*math.Log(r)) * 180 / math.Pi
+}
+
+// updateWindDegStddev records a wind direction for the station and sets the
+// stability metric once enough history has been collected.
+func updateWindDegStddev(station string, observed time.Time, deg float64) {
+	history := windHistory.Add(station, observed, deg)
+	if len(history) < windStddevMinSamples {
+		return
+	}
+	degrees := make([]float64, len(history))
+	for i, reading := range history {
+		degrees[i] = reading.Value
+	}
+	owWeatherWindDegStddev.WithLabelValues(station).Set(circularStddev(degrees))
+}
+
+// iconEmojis maps the condition part of OpenWeather icon codes to an emoji
+var iconEmojis = map[string]string{
+	"01": "☀️",
+	"02": "🌤️",
+	"03": "☁️",
+	"04": "☁️",
+	"09": "🌧️",
+	"10": "🌦️",
+	"11": "⛈️",
+	"13": "❄️",
+	"50": "🌫️",
+}
+
+// iconEmoji returns the emoji for an OpenWeather icon code such as "10d"
+func iconEmoji(icon string) string {
+	if icon == "01n" {
+		return "🌙"
+	}
+	if len(icon) < 2 {
+		return ""
+	}
+	return iconEmojis[icon[:2]]
+}
+
+// beaufortLimits are the upper wind speed limits in m/s of Beaufort forces 0 to 11
+var beaufortLimits = [...]float64{0.5, 1.6, 3.4, 5.5, 8.0, 10.8, 13.9, 17.2, 20.8, 24.5, 28.5, 32.7}
+
+// beaufortDescriptions are the names of Beaufort forces 0 to 12
+var beaufortDescriptions = [...]string{
+	"Calm", "Light air", "Light breeze", "Gentle breeze", "Moderate breeze",
+	"Fresh breeze", "Strong breeze", "Near gale", "Gale", "Strong gale",
+	"Storm", "Violent storm", "Hurricane force",
+}
+
+// beaufortForce returns the Beaufort force of a wind speed given in the
+// speed unit of the unit system
+func beaufortForce(speed float64, units string) int {
+	metersPerSecond := convertSpeed(speed, units, "metric")
+	for force, limit := range beaufortLimits {
+		if metersPerSecond < limit {
+			return force
+		}
+	}
+	return len(beaufortLimits)
+}
+
+// secondsUntilNext returns the seconds from now until the next occurrence of a
+// daily event, estimating it a day later for every day it has already passed.
+func secondsUntilNext(event time.Time, now time.Time) float64 {
+	for !event.After(now) {
+		event = event.Add(24 * time.Hour)
+	}
+	return event.Sub(now).Seconds()
+}
+
+// comfortLimits are the upper feels like temperatures in °C of each comfort
+// band, the last band is open-ended
+var comfortLimits = [...]float64{10, 18, 24, 30}
+
+// comfortBands are the names of the comfort bands
+var comfortBands = [...]string{"cold", "cool", "comfortable", "warm", "hot"}
+
+// comfortBand returns the comfort band of a feels like temperature given in
+// the units of the unit system
+func comfortBand(feelsLike float64, units string) string {
+	celsius := convertTemp(feelsLike, units, "metric")
+	for band, limit := range comfortLimits {
+		if celsius < limit {
+			return comfortBands[band]
+		}
+	}
+	return comfortBands[len(comfortLimits)]
+}
+
+// aqiBreakpoints are the concentrations in μg/m³ at which OpenWeather's AQI
+// levels 2 to 5 start for each pollutant
+var aqiBreakpoints = map[string][4]float64{
+	"so2":   {20, 80, 250, 350},
+	"no2":   {40, 70, 150, 200},
+	"pm10":  {20, 50, 100, 200},
+	"pm2_5": {10, 25, 50, 75},
+	"o3":    {60, 100, 140, 180},
+	"co":    {4400, 9400, 12400, 15400},
+}
+
+// aqiSubIndex returns the AQI level (1-5) of a pollutant concentration
+func aqiSubIndex(pollutant string, concentration float64) int {
+	index := 1
+	for _, start := range aqiBreakpoints[pollutant] {
+		if concentration >= start {
+			index++
+		}
+	}
+	return index
+}
+
+// updateAQIDelta sets the AQI delta metric relative to the station's previous
+// AQI. Nothing is reported until a baseline has been recorded.
+func updateAQIDelta(station string, aqi int) {
+	history := aqiHistory.Add(station, clk.Now(), float64(aqi))
+	if n := len(history); n >= 2 {
+		owAirPollutionAQIDelta.WithLabelValues(station).Set(history[n-1].Value - history[n-2].Value)
+	}
+}
+
+// httpClient is used for all requests to OpenWeather
+var httpClient = &http.Client{}
+
+// thirdPartyHTTPClient is used for requests to other providers, e.g.
+// AIR_POLLUTION_FALLBACK_URL. It never presents the OpenWeather client
+// certificate.
+var thirdPartyHTTPClient = &http.Client{}
+
+// newHTTPClient returns a client for API requests, keeping up to
+// maxIdleConns idle connections, or any number for 0, open for
+// idleConnTimeout and presenting the given client certificate when certFile
+// and keyFile are set.
+func newHTTPClient(certFile, keyFile string, maxIdleConns int, idleConnTimeout time.Duration) (*http.Client, error) {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	// All requests go to the same host, so allow it to use the whole pool
+	transport.MaxIdleConns = maxIdleConns
+	transport.MaxIdleConnsPerHost = maxIdleConns
+	if maxIdleConns == 0 {
+		// 0 means no limit for MaxIdleConns, but the default of 2 per host
+		transport.MaxIdleConnsPerHost = math.MaxInt
+	}
+	transport.IdleConnTimeout = idleConnTimeout
+
+	if certFile == "" && keyFile == "" {
+		return &http.Client{Transport: transport, CheckRedirect: logRedirect}, nil
+	}
+	if certFile == "" || keyFile == "" {
+		return nil, fmt.Errorf("OPENWEATHER_CLIENT_CERT and OPENWEATHER_CLIENT_KEY must be set together")
+	}
+	cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load client certificate: %w", err)
+	}
+	transport.TLSClientConfig = &tls.Config{Certificates: []tls.Certificate{cert}}
+	return &http.Client{Transport: transport, CheckRedirect: logRedirect}, nil
+}
+
+// maxResponseBytes is the largest response body read from OpenWeather, or 0
+// for no limit
+var maxResponseBytes int64 = 1 << 20
+
+// isJSONContentType reports whether a Content-Type header denotes JSON
+func isJSONContentType(contentType string) bool {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return false
+	}
+	return mediaType == "application/json" || strings.HasSuffix(mediaType, "+json")
+}
+
+// fetchJSON requests url from OpenWeather with GET and decodes the JSON
+// response into v. The endpoint is used to label metrics and name describes
+// the data in errors.
+func fetchJSON(ctx context.Context, endpoint, name, url string, v any) error {
+	return requestJSON(ctx, httpClient, true, http.MethodGet, endpoint, name, url, nil, v)
+}
+
+// fetchThirdPartyJSON is fetchJSON for providers other than OpenWeather. Its
+// requests do not count toward the OpenWeather API usage and do not change
+// ow_api_key_valid.
+func fetchThirdPartyJSON(ctx context.Context, endpoint, name, url string, v any) error {
+	return requestJSON(ctx, thirdPartyHTTPClient, false, http.MethodGet, endpoint, name, url, nil, v)
+}
+
+// requestJSON sends a request with the given client and method and decodes
+// the JSON response into v. A non-nil body is sent as JSON, e.g. for bulk
+// queries. Only requests to OpenWeather count toward the API usage and set
+// the API key validity.
+func requestJSON(ctx context.Context, client *http.Client, openWeather bool, method, endpoint, name, url string, body []byte, v any) (err error) {
+	defer observeScrapeDuration(endpoint, time.Now())
+	defer func() {
+		if err != nil {
+			countScrapeError(endpoint, errorReason(err))
+		}
+	}()
+
+	var reqBody io.Reader
+	if body != nil {
+		reqBody = bytes.NewReader(body)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, url, reqBody)
+	if err != nil {
+		return fmt.Errorf("failed to create %s request: %w", name, err)
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	resp, err := doWithDNSRetry(client, req)
+	if err != nil {
+		if openWeather {
+			countAPIRequest(clk.Now())
+		}
+		owAPIRequests.WithLabelValues(req.URL.Host, method, "error").Inc()
+		if isTimeout(err) {
+			return &TimeoutError{Name: name, Err: err}
+		}
+		return fmt.Errorf("failed to fetch %s data: %w", name, err)
+	}
+	defer resp.Body.Close()
+	owAPIRequests.WithLabelValues(req.URL.Host, method, strconv.Itoa(resp.StatusCode)).Inc()
+
+	if openWeather {
+		countAPIRequest(clk.Now())
+		switch resp.StatusCode {
+		case http.StatusOK:
+			setAPIKeyValid(true)
+		case http.StatusUnauthorized:
+			setAPIKeyValid(false)
+		}
+	}
+	if resp.StatusCode != http.StatusOK {
+		return &StatusError{Name: name, Code: resp.StatusCode}
+	}
+
+	// Proxies or a leaked mode=xml can return HTML or XML instead of JSON
+	if contentType := resp.Header.Get("Content-Type"); !isJSONContentType(contentType) {
+		// Name where a redirect ended up, e.g. at the login page of a proxy
+		if final := resp.Request.URL; final.String() != req.URL.String() {
+			return &DecodeError{Name: name, Err: fmt.Errorf("content type %q is not JSON after a redirect to %s", contentType, redactURL(final.String()))}
+		}
+		return &DecodeError{Name: name, Err: fmt.Errorf("content type %q is not JSON", contentType)}
+	}
+
+	// Read one byte more than allowed to tell a body at the limit from a
+	// larger one, without buffering an unexpectedly huge response
+	reader := io.Reader(resp.Body)
+	if maxResponseBytes > 0 {
+		if resp.ContentLength > maxResponseBytes {
+			return &TooLargeError{Name: name, Limit: maxResponseBytes}
+		}
+		reader = io.LimitReader(resp.Body, maxResponseBytes+1)
+	}
+	respBody, err := io.ReadAll(reader)
+	if err != nil {
+		if isTimeout(err) {
+			return &TimeoutError{Name: name, Err: err}
+		}
+		return fmt.Errorf("failed to read %s response: %w", name, err)
+	}
+	if maxResponseBytes > 0 && int64(len(respBody)) > maxResponseBytes {
+		return &TooLargeError{Name: name, Limit: maxResponseBytes}
+	}
+	owAPIResponseBytes.WithLabelValues(endpoint).Set(float64(len(respBody)))
+	owAPIReceivedBytes.Add(float64(len(respBody)))
+	recordRawResponse(endpoint, url, respBody)
+
+	if err := json.Unmarshal(respBody, v); err != nil {
+		return &DecodeError{Name: name, Err: err}
+	}
+	return nil
+}
+
+func fetchWeatherData(ctx context.Context, url, units string) (*WeatherResponse, error) {
+	var weather WeatherResponse
+	if err := fetchJSON(ctx, "weather", "weather", url, &weather); err != nil {
+		return nil, err
+	}
+
+	station := setWeatherMetrics(&weather, units)
+	if influx != nil {
+		influx.queueWeather(station, &weather)
+	}
+	return &weather, nil
+}
+
+func fetchGroupWeatherData(ctx context.Context, url string) ([]WeatherResponse, error) {
+	var group GroupResponse
+	if err := fetchJSON(ctx, "group", "group weather", url, &group); err != nil {
+		return nil, err
+	}
+
+	for i := range group.List {
+		station := setWeatherMetrics(&group.List[i], units)
+		if influx != nil {
+			influx.queueWeather(station, &group.List[i])
+		}
+	}
+
+	return group.List, nil
+}
+
+// stationLocks serializes the metric updates of each station, so the series
+// of a station that is updated from several locations at once change
+// together, e.g. a condition is never deleted by one update after another set
+// the new one
+var stationLocks sync.Map
+
+// lockStation locks the metrics of the station and returns the unlock function
+func lockStation(station string) func() {
+	mu, _ := stationLocks.LoadOrStore(station, new(sync.Mutex))
+	mu.(*sync.Mutex).Lock()
+	return mu.(*sync.Mutex).Unlock
+}
+
+// weatherCondition is the condition exported for a station, empty when
+// OpenWeather returned none
+type weatherCondition struct {
+	main, description, icon string
+}
+
+// lastConditions remembers the condition last exported per station, so an
+// unchanged condition is not deleted and set again on every update
+var (
+	lastConditionsMu sync.Mutex
+	lastConditions   = make(map[string]weatherCondition)
+)
+
+// conditionChanged records the station's condition and reports whether it
+// differs from the previous one
+func conditionChanged(station string, condition weatherCondition) bool {
+	lastConditionsMu.Lock()
+	defer lastConditionsMu.Unlock()
+	previous, ok := lastConditions[station]
+	lastConditions[station] = condition
+	return !ok || previous != condition
+}
+
+// forgetCondition drops the remembered condition once the station's series
+// have been deleted
+func forgetCondition(station string) {
+	lastConditionsMu.Lock()
+	defer lastConditionsMu.Unlock()
+	delete(lastConditions, station)
+}
+
+// conditionFilter restricts the conditions exported by ow_weather_condition.
+// Entries match the main group or the description of a condition, ignoring
+// case. Conditions matching a deny entry are never exported, and when there
+// are allow entries, only conditions matching one of them are.
+type conditionFilter struct {
+	allow map[string]bool
+	deny  map[string]bool
+}
+
+// weatherConditionFilter is the CONDITION_FILTER, exporting all conditions by
+// default
+var weatherConditionFilter conditionFilter
+
+// parseConditionFilter parses a comma separated list of conditions to allow,
+// and of conditions prefixed with ! to deny, e.g. "Thunderstorm,Snow" or
+// "!Clouds"
+func parseConditionFilter(value string) (conditionFilter, error) {
+	filter := conditionFilter{allow: make(map[string]bool), deny: make(map[string]bool)}
+	for _, entry := range strings.Split(value, ",") {
+		entry = strings.ToLower(strings.TrimSpace(entry))
+		if name, ok := strings.CutPrefix(entry, "!"); ok {
+			name = strings.TrimSpace(name)
+			if name == "" {
+				return conditionFilter{}, fmt.Errorf("empty condition after !")
+			}
+			filter.deny[name] = true
+		} else if entry != "" {
+			filter.allow[entry] = true
+		}
+	}
+	if len(filter.allow) == 0 && len(filter.deny) == 0 {
+		return conditionFilter{}, fmt.Errorf("no conditions given")
+	}
+	return filter, nil
+}
+
+// match reports whether a condition passes the filter
+func (f conditionFilter) match(main, description string) bool {
+	main, description = strings.ToLower(main), strings.ToLower(description)
+	if f.deny[main] || f.deny[description] {
+		return false
+	}
+	return len(f.allow) == 0 || f.allow[main] || f.allow[description]
+}
+
+// isPrecipitating reports whether a condition is a thunderstorm (2xx),
+// drizzle (3xx), rain (5xx), or snow (6xx), or rain or snow fell in the last
+// hour
+func isPrecipitating(weather *WeatherResponse) bool {
+	if weather.Rain.OneHour > 0 || weather.Snow.OneHour > 0 {
+		return true
+	}
+	for _, condition := range weather.Weather {
+		switch condition.ID / 100 {
+		case 2, 3, 5, 6:
+			return true
+		}
+	}
+	return false
+}
+
+// setWeatherMetrics updates the weather metrics from a decoded response in the
+// given unit system and returns the station label used.
+func setWeatherMetrics(weather *WeatherResponse, units string) string {
+	station := strconv.Itoa(weather.ID)
+	defer lockStation(station)()
+	recordObservation("ow_weather_", station, time.Unix(weather.Dt, 0))
+
+	// Update weather metrics
+	owWeatherTemp.WithLabelValues(station, units).Set(roundValue(weather.Main.Temp))
+	owWeatherFeelsLike.WithLabelValues(station, units).Set(roundValue(weather.Main.FeelsLike))
+	updateFeelsLikeRate(station, units, time.Unix(weather.Dt, 0), weather.Main.FeelsLike)
+	if forecastEnabled {
+		updateForecastError(station, units, weather.Dt, weather.Main.Temp)
+	}
+	owWeatherTempMin.WithLabelValues(station, units).Set(roundValue(weather.Main.TempMin))
+	owWeatherTempMax.WithLabelValues(station, units).Set(roundValue(weather.Main.TempMax))
+	owWeatherTempCelsius.WithLabelValues(station).Set(roundValue(convertTemp(weather.Main.Temp, units, "metric")))
+	owWeatherTempFahrenheit.WithLabelValues(station).Set(roundValue(convertTemp(weather.Main.Temp, units, "imperial")))
+	updateIdenticalReadings(station, weather.Main.Temp)
+	owWeatherPressure.WithLabelValues(station).Set(roundValue(weather.Main.Pressure))
+	owWeatherPressureInHg.WithLabelValues(station).Set(roundValue(weather.Main.Pressure * inHgPerHPa))
+	updatePressureTrend(station, time.Unix(weather.Dt, 0), weather.Main.Pressure)
+	owWeatherHumidity.WithLabelValues(station).Set(roundValue(weather.Main.Humidity))
+	if weather.Main.Humidity > humidityThreshold {
+		owWeatherHighHumidity.WithLabelValues(station).Set(1)
+	} else {
+		owWeatherHighHumidity.WithLabelValues(station).Set(0)
+	}
+	celsius := convertTemp(weather.Main.Temp, units, "metric")
+	owWeatherAbsoluteHumidity.WithLabelValues(station).Set(roundValue(absoluteHumidity(celsius, weather.Main.Humidity)))
+	setOptionalGauge(owWeatherSeaLevel, station, weather.Main.SeaLevel)
+	setOptionalGauge(owWeatherGrndLevel, station, weather.Main.GrndLevel)
+	owWeatherVisibility.WithLabelValues(station).Set(roundValue(weather.Visibility))
+	owWeatherWindSpeed.WithLabelValues(station, units).Set(roundValue(weather.Wind.Speed))
+	if smoothingAlpha > 0 {
+		owWeatherTempSmoothed.WithLabelValues(station, units).Set(roundValue(tempAverage.Update(station, weather.Main.Temp, smoothingAlpha)))
+		owWeatherWindSpeedSmoothed.WithLabelValues(station, units).Set(roundValue(windSpeedAverage.Update(station, weather.Wind.Speed, smoothingAlpha)))
+	}
+	owWeatherWindDeg.WithLabelValues(station).Set(roundValue(weather.Wind.Deg))
+	updateWindDegStddev(station, time.Unix(weather.Dt, 0), weather.Wind.Deg)
+	if targetBearing >= 0 {
+		owWeatherWindOffset.WithLabelValues(station).Set(roundValue(angularDifference(weather.Wind.Deg, targetBearing)))
+	}
+	force := beaufortForce(weather.Wind.Speed, units)
+	owWeatherWindBeaufort.WithLabelValues(station).Set(float64(force))
+	owWeatherWindBeaufortInfo.DeletePartialMatch(prometheus.Labels{"station": station})
+	owWeatherWindBeaufortInfo.WithLabelValues(station, beaufortDescriptions[force]).Set(1)
+	owWeatherSunTimesInfo.DeletePartialMatch(prometheus.Labels{"station": station})
+	// Polar day and night have no sunrise or sunset
+	if weather.Sys.Sunrise != 0 && weather.Sys.Sunset != 0 {
+		now := clk.Now()
+		owWeatherSecondsToSunrise.WithLabelValues(station).Set(math.Round(secondsUntilNext(time.Unix(weather.Sys.Sunrise, 0), now)))
+		owWeatherSecondsToSunset.WithLabelValues(station).Set(math.Round(secondsUntilNext(time.Unix(weather.Sys.Sunset, 0), now)))
+
+		zone := time.FixedZone("", weather.Timezone)
+		sunrise := time.Unix(weather.Sys.Sunrise, 0).In(zone).Format("15:04")
+		sunset := time.Unix(weather.Sys.Sunset, 0).In(zone).Format("15:04")
+		owWeatherSunTimesInfo.WithLabelValues(station, sunrise, sunset).Set(1)
+	} else {
+		// The countdowns of the last regular day would be wrong from now on
+		owWeatherSecondsToSunrise.DeleteLabelValues(station)
+		owWeatherSecondsToSunset.DeleteLabelValues(station)
+	}
+	owWeatherComfortInfo.DeletePartialMatch(prometheus.Labels{"station": station})
+	owWeatherComfortInfo.WithLabelValues(station, comfortBand(weather.Main.FeelsLike, units)).Set(1)
+	owWeatherClouds.WithLabelValues(station).Set(roundValue(weather.Clouds.All))
+	rain := rainToday.Add(station, time.Unix(weather.Dt, 0), time.FixedZone("", weather.Timezone), weather.Rain.OneHour)
+	owWeatherRainAccumulated.WithLabelValues(station).Set(roundValue(rain))
+	if isPrecipitating(weather) {
+		owWeatherIsPrecipitating.WithLabelValues(station).Set(1)
+	} else {
+		owWeatherIsPrecipitating.WithLabelValues(station).Set(0)
+	}
+
+	if temperaturePlausible(weather.Main.Temp, units) {
+		owWeatherUnitSanity.WithLabelValues(station).Set(1)
+	} else {
+		owWeatherUnitSanity.WithLabelValues(station).Set(0)
+	}
+
+	owWeatherConditionCount.WithLabelValues(station).Set(float64(len(weather.Weather)))
+	for field, present := range weather.Present {
+		if present {
+			owWeatherFieldsPresent.WithLabelValues(station, field).Set(1)
+		} else {
+			owWeatherFieldsPresent.WithLabelValues(station, field).Set(0)
+		}
+	}
+	owWeatherCompleteness.WithLabelValues(station).Set(weather.Completeness)
+
+	// Replace the station's weather condition (set to 1 to indicate active),
+	// so a previous condition does not linger once it has changed or is missing
+	var condition weatherCondition
+	if len(weather.Weather) > 0 {
+		condition = weatherCondition{weather.Weather[0].Main, weather.Weather[0].Description, weather.Weather[0].Icon}
+	}
+	if conditionChanged(station, condition) {
+		owWeatherCondition.DeletePartialMatch(prometheus.Labels{"station": station})
+		owWeatherIconInfo.DeletePartialMatch(prometheus.Labels{"station": station})
+		if len(weather.Weather) > 0 {
+			if weatherConditionFilter.match(condition.main, condition.description) {
+				owWeatherCondition.WithLabelValues(station, condition.main, condition.description).Set(1)
+			}
+			owWeatherIconInfo.WithLabelValues(station, condition.icon, iconEmoji(condition.icon)).Set(1)
+		}
+	}
+	if len(weather.Weather) == 0 {
+		owWeatherMissingCondition.WithLabelValues(station).Inc()
+	}
+
+	return station
+}
+
+// fetchAirPollutionData updates the air pollution metrics of the station and
+// returns the response used
+func fetchAirPollutionData(ctx context.Context, url, fallbackURL, station string) (*AirPollutionResponse, error) {
+	var pollution AirPollutionResponse
+	if err := fetchJSON(ctx, "air_pollution", "air pollution", url, &pollution); err != nil {
+		return nil, err
+	}
+	source := "openweather"
+	if len(pollution.List) == 0 && fallbackURL != "" {
+		var fallback AirPollutionResponse
+		if err := fetchThirdPartyJSON(ctx, "air_pollution_fallback", "fallback air pollution", fallbackURL, &fallback); err != nil {
+			return nil, err
+		}
+		pollution, source = fallback, "fallback"
+	}
+
+	// Update air pollution metrics. The current data endpoint returns a single
+	// entry, more indicate a forecast or history response.
+	unlock := lockStation(station)
+	owAirPollutionListLength.WithLabelValues(station).Set(float64(len(pollution.List)))
+	if len(pollution.List) > 0 {
+		data := pollution.List[0]
+		recordObservation("ow_air_pollution_", station, time.Unix(data.Dt, 0))
+		// Drop the series of the other provider, so only the values in use
+		// remain once a station switches between them
+		for _, vec := range airPollutionSourceMetrics() {
+			for _, other := range airPollutionSources {
+				if other != source {
+					vec.DeletePartialMatch(prometheus.Labels{"station": station, "source": other})
+				}
+			}
+		}
+		owAirPollutionAQI.WithLabelValues(station, source).Set(float64(data.Main.AQI))
+		updateAQIDelta(station, data.Main.AQI)
+		owAirPollutionObservationTime.WithLabelValues(station).Set(float64(data.Dt))
+		owAirPollutionCO.WithLabelValues(station, source).Set(roundValue(data.Components.CO))
+		owAirPollutionNO.WithLabelValues(station, source).Set(roundValue(data.Components.NO))
+		owAirPollutionNO2.WithLabelValues(station, source).Set(roundValue(data.Components.NO2))
+		owAirPollutionO3.WithLabelValues(station, source).Set(roundValue(data.Components.O3))
+		owAirPollutionSO2.WithLabelValues(station, source).Set(roundValue(data.Components.SO2))
+		owAirPollutionPM25.WithLabelValues(station, source).Set(roundValue(data.Components.PM25))
+		owAirPollutionPM10.WithLabelValues(station, source).Set(roundValue(data.Components.PM10))
+		owAirPollutionNH3.WithLabelValues(station, source).Set(roundValue(data.Components.NH3))
+
+		concentrations := map[string]float64{
+			"so2":   data.Components.SO2,
+			"no2":   data.Components.NO2,
+			"pm10":  data.Components.PM10,
+			"pm2_5": data.Components.PM25,
+			"o3":    data.Components.O3,
+			"co":    data.Components.CO,
+		}
+		for pollutant, concentration := range concentrations {
+			owAirPollutionSubIndex.WithLabelValues(station, source, pollutant).Set(float64(aqiSubIndex(pollutant, concentration)))
+		}
+	}
+	unlock()
+
+	if influx != nil {
+		influx.queueAirPollution(station, &pollution)
+	}
+
+	return &pollution, nil
+}
+
+// coordPrecision is the number of decimal places of coordinates in labels and
+// on /status, or -1 to keep them as configured
+var coordPrecision = -1
+
+// coordLabel rounds a coordinate for use in labels. Requests to OpenWeather
+// always use the full precision.
+func coordLabel(coordinate string) string {
+	if coordPrecision < 0 {
+		return coordinate
+	}
+	value, err := strconv.ParseFloat(coordinate, 64)
+	if err != nil {
+		return coordinate
+	}
+	return strconv.FormatFloat(value, 'f', coordPrecision, 64)
+}
+
+// setStationInfo replaces the info series for the station. base is the data
+// source reported by OpenWeather, e.g. "stations".
+func setStationInfo(station, name, latitude, longitude, base string) {
+	defer lockStation(station)()
+	owStationInfo.DeletePartialMatch(prometheus.Labels{"station": station})
+	owStationInfo.WithLabelValues(station, name, coordLabel(latitude), coordLabel(longitude), base).Set(1)
+}
+
+// updateMetrics fetches the data for a location. It reports whether the
+// weather could be updated and returns all errors joined together, which
+// are also logged.
+func updateMetrics(ctx context.Context, loc location) (bool, error) {
+	key := locationKey(loc)
+	weather, err := fetchWeatherData(ctx, loc.weatherURL, loc.Units)
+	failures.report("weather data for location "+key, err)
+	if err != nil {
+		var statusErr *StatusError
+		if errors.As(err, &statusErr) && statusErr.Code == http.StatusUnauthorized {
+			log.Printf("OpenWeather rejected the API key, check OPENWEATHER_API_KEY")
+		}
+		recordLocationStatus(key, loc, "", nil, err)
+		return false, err
+	}
+
+	station := strconv.Itoa(weather.ID)
+	name := weather.Name
+	if loc.Name != "" {
+		name = loc.Name
+	}
+	setStationInfo(station, name, loc.Latitude, loc.Longitude, weather.Base)
+	setStationLabels(station, loc.Labels)
+	markStationUpdated(station, clk.Now())
+
+	err = updateStationMetrics(ctx, loc, station, weather)
+	recordLocationStatus(key, loc, station, weather, err)
+	return true, err
+}
+
+// updateLocations updates all locations, at most concurrency at a time. It
+// returns the number of locations whose weather was updated and all errors
+// joined together. The shared metric and history state is safe for concurrent
+// use, results are collected per location and combined once all are done.
+func updateLocations(ctx context.Context, locations []location, concurrency int) (int, error) {
+	updated := make([]bool, len(locations))
+	errs := make([]error, len(locations))
+	sem := make(chan struct{}, concurrency)
+	var wg sync.WaitGroup
+	for i, loc := range locations {
+		wg.Add(1)
+		sem <- struct{}{}
+		go func() {
+			defer wg.Done()
+			defer func() { <-sem }()
+			// Panics in this goroutine would not reach the update loop
+			defer recoverPanic(&errs[i])
+			updated[i], errs[i] = updateMetrics(ctx, loc)
+		}()
+	}
+	wg.Wait()
+
+	active := 0
+	for _, ok := range updated {
+		if ok {
+			active++
+		}
+	}
+	return active, errors.Join(errs...)
+}
+
+// updateGroupMetrics fetches the data for all cities in the group. It returns
+// the number of cities whose weather was updated and all errors joined together.
+func updateGroupMetrics(ctx context.Context, groupURL, apiKey string) (int, error) {
+	cities, err := fetchGroupWeatherData(ctx, groupURL)
+	failures.report("group weather data", err)
+	if err != nil {
+		recordStatusError(err)
+		return 0, err
+	}
+
+	// The group endpoint only covers current weather, so query the rest per city
+	var errs []error
+	for i, city := range cities {
+		loc := location{
+			Latitude:  strconv.FormatFloat(city.Coord.Lat, 'f', -1, 64),
+			Longitude: strconv.FormatFloat(city.Coord.Lon, 'f', -1, 64),
+			Name:      city.Name,
+			Units:     units,
+		}
+		loc.buildURLs(apiKey)
+		station := strconv.Itoa(city.ID)
+		setStationInfo(station, loc.Name, loc.Latitude, loc.Longitude, city.Base)
+		markStationUpdated(station, clk.Now())
+		err := updateStationMetrics(ctx, loc, station, &cities[i])
+		recordLocationStatus(station, loc, station, &cities[i], err)
+		errs = append(errs, err)
+	}
+	return len(cities), errors.Join(errs...)
+}
+
+// updateStationMetrics fetches the remaining data for a station whose weather
+// has been updated
+func updateStationMetrics(ctx context.Context, loc location, station string, weather *WeatherResponse) error {
+	var errs []error
+	pollution, err := fetchAirPollutionData(ctx, loc.pollutionURL, loc.pollutionFallbackURL, station)
+	failures.report("air pollution data for station "+station, err)
+	if err == nil && len(pollution.List) > 0 {
+		owWeatherScore.WithLabelValues(station).Set(roundValue(weatherScore(weather, loc.Units, pollution.List[0].Main.AQI)))
+	} else {
+		// Drop the previous values rather than showing them as current while
+		// only the air pollution endpoint fails. The score needs the AQI too.
+		unlock := lockStation(station)
+		if err != nil {
+			for _, vec := range airPollutionMetrics() {
+				vec.DeletePartialMatch(prometheus.Labels{"station": station})
+			}
+		}
+		owWeatherScore.DeleteLabelValues(station)
+		unlock()
+	}
+	errs = append(errs, err)
+
+	if solarEnabled {
+		err := fetchSolarRadiationData(ctx, loc.solarURL, station)
+		failures.report("solar radiation data for station "+station, err)
+		errs = append(errs, err)
+	}
+
+	if forecastEnabled {
+		err := fetchForecastData(ctx, loc.forecastURL, station)
+		failures.report("forecast data for station "+station, err)
+		errs = append(errs, err)
+	}
+
+	if daily16Enabled {
+		err := fetchDaily16Data(ctx, loc.daily16URL, station, loc.Units)
+		failures.report("daily forecast data for station "+station, err)
+		errs = append(errs, err)
+	}
+
+	if overviewEnabled {
+		err := fetchOverviewData(ctx, loc.overviewURL, station)
+		failures.report("weather overview for station "+station, err)
+		errs = append(errs, err)
+	}
+	return errors.Join(errs...)
+}
+
+// airPollutionURL has no mode=json like the weather URLs, the Air Pollution
+// API only answers in JSON and has no mode parameter
+func airPollutionURL(latitude, longitude, apiKey string) string {
+	return fmt.Sprintf("%s%s?lat=%s&lon=%s&appid=%s", apiBaseURL, airPollutionPath, latitude, longitude, apiKey)
+}
+
+// location is a configured place to monitor
+type location struct {
+	Latitude  string
+	Longitude string
+	// Name overrides the name reported by OpenWeather when set
+	Name string
+	// Units is the unit system of the location, defaulting to UNITS
+	Units string
+	// Labels are extra labels added to all series of the location
+	Labels map[string]string
+
+	weatherURL   string
+	pollutionURL string
+	// pollutionFallbackURL is empty unless AIR_POLLUTION_FALLBACK_URL is set
+	pollutionFallbackURL string
+	solarURL             string
+	forecastURL          string
+	daily16URL           string
+	overviewURL          string
+}
+
+// buildURLs sets the OpenWeather request URLs for the location
+func (loc *location) buildURLs(apiKey string) {
+	loc.weatherURL = fmt.Sprintf("%s/data/2.5/weather?lat=%s&lon=%s&appid=%s&units=%s&mode=json", apiBaseURL, loc.Latitude, loc.Longitude, apiKey, loc.Units)
+	loc.pollutionURL = airPollutionURL(loc.Latitude, loc.Longitude, apiKey)
+	if airPollutionFallbackURL != "" {
+		loc.pollutionFallbackURL = strings.NewReplacer("{lat}", loc.Latitude, "{lon}", loc.Longitude).Replace(airPollutionFallbackURL)
+	}
+	loc.solarURL = solarRadiationURL(loc.Latitude, loc.Longitude, apiKey)
+	loc.forecastURL = forecastURL(loc.Latitude, loc.Longitude, apiKey, loc.Units)
+	loc.daily16URL = daily16URL(loc.Latitude, loc.Longitude, apiKey, loc.Units)
+	loc.overviewURL = overviewURL(loc.Latitude, loc.Longitude, apiKey, loc.Units)
+}
+
+// coordinateDecimals is the number of decimal places coordinates are
+// normalized to, about 0.1 m
+const coordinateDecimals = 6
+
+// normalizeCoordinate parses a latitude or longitude, checks that it is
+// within ±limit, and formats it consistently, so the same coordinate always
+// results in the same request URL and label value
+func normalizeCoordinate(name, value string, limit float64) (string, error) {
+	value = strings.TrimSpace(value)
+	if strings.Contains(value, ",") {
+		return "", fmt.Errorf("%s %q must use a dot as decimal separator", name, value)
+	}
+	coordinate, err := strconv.ParseFloat(value, 64)
+	if err != nil || math.IsNaN(coordinate) || math.Abs(coordinate) > limit {
+		return "", fmt.Errorf("%s %q must be a number between -%g and %g", name, value, limit, limit)
+	}
+	scale := math.Pow10(coordinateDecimals)
+	return strconv.FormatFloat(math.Round(coordinate*scale)/scale, 'f', -1, 64), nil
+}
+
+// normalizeCoordinates validates and normalizes the coordinates of the
+// location
+func (loc *location) normalizeCoordinates() error {
+	latitude, err := normalizeCoordinate("latitude", loc.Latitude, 90)
+	if err != nil {
+		return err
+	}
+	longitude, err := normalizeCoordinate("longitude", loc.Longitude, 180)
+	if err != nil {
+		return err
+	}
+	loc.Latitude, loc.Longitude = latitude, longitude
+	return nil
+}
+
+// parseLocations parses a semicolon separated list of
+// "latitude,longitude[,name[,units]]" entries. Names may reference other
+// environment variables, e.g. ${SITE_NAME}.
+func parseLocations(value string) ([]location, error) {
+	var locations []location
+	for _, entry := range strings.Split(value, ";") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		fields := strings.Split(entry, ",")
+		if len(fields) < 2 || len(fields) > 4 {
+			return nil, fmt.Errorf("location %q must be in the form latitude,longitude[,name[,units]]", entry)
+		}
+		loc := location{
+			Latitude:  strings.TrimSpace(fields[0]),
+			Longitude: strings.TrimSpace(fields[1]),
+		}
+		if loc.Latitude == "" || loc.Longitude == "" {
+			return nil, fmt.Errorf("location %q is missing a coordinate", entry)
+		}
+		if err := loc.normalizeCoordinates(); err != nil {
+			return nil, fmt.Errorf("location %q: %w", entry, err)
+		}
+		if len(fields) > 2 {
+			loc.Name = strings.TrimSpace(os.ExpandEnv(fields[2]))
+		}
+		if len(fields) > 3 {
+			loc.Units = strings.TrimSpace(fields[3])
+			if !validUnits(loc.Units) {
+				return nil, fmt.Errorf("location %q has invalid units %q", entry, loc.Units)
+			}
+		}
+		locations = append(locations, loc)
+	}
+	if len(locations) == 0 {
+		return nil, fmt.Errorf("no locations given")
+	}
+	return locations, nil
+}
+
+// maxGroupCityIDs is the limit of city IDs accepted by the group endpoint
+const maxGroupCityIDs = 20
+
+// parseCityIDs splits a comma separated list of OpenWeather city IDs
+func parseCityIDs(value string) ([]string, error) {
+	var ids []string
+	for _, id := range strings.Split(value, ",") {
+		id = strings.TrimSpace(id)
+		if id == "" {
+			continue
+		}
+		if _, err := strconv.Atoi(id); err != nil {
+			return nil, fmt.Errorf("invalid city ID %q", id)
+		}
+		ids = append(ids, id)
+	}
+	if len(ids) == 0 {
+		return nil, fmt.Errorf("no city IDs given")
+	}
+	if len(ids) > maxGroupCityIDs {
+		return nil, fmt.Errorf("at most %d city IDs are supported, got %d", maxGroupCityIDs, len(ids))
+	}
+	return ids, nil
+}
+
+// parsePort checks that port is a number between 1 and 65535
+func parsePort(port string) error {
+	n, err := strconv.Atoi(port)
+	if err != nil || n < 1 || n > 65535 {
+		return fmt.Errorf("port %q must be a number between 1 and 65535", port)
+	}
+	return nil
+}
+
+// listenAddress returns the address for the HTTP server. LISTEN_ADDRESS takes
+// precedence over EXPORTER_PORT when set.
+func listenAddress(address, port string) (string, error) {
+	if address == "" {
+		if err := parsePort(port); err != nil {
+			return "", fmt.Errorf("invalid EXPORTER_PORT: %w", err)
+		}
+		return ":" + port, nil
+	}
+	_, addressPort, err := net.SplitHostPort(address)
+	if err != nil {
+		return "", fmt.Errorf("invalid LISTEN_ADDRESS: %w", err)
+	}
+	if err := parsePort(addressPort); err != nil {
+		return "", fmt.Errorf("invalid LISTEN_ADDRESS: %w", err)
+	}
+	return address, nil
+}
+
+// parseBaseURL validates an OPENWEATHER_BASE_URL value and strips any
+// trailing slash
+func parseBaseURL(value string) (string, error) {
+	u, err := url.Parse(value)
+	if err != nil {
+		return "", fmt.Errorf("invalid OPENWEATHER_BASE_URL: %w", err)
+	}
+	if (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return "", fmt.Errorf("invalid OPENWEATHER_BASE_URL %q: must be an http or https URL", value)
+	}
+	return strings.TrimSuffix(value, "/"), nil
+}
+
+// finalPushTimeout bounds the Pushgateway push made during shutdown
+const finalPushTimeout = 5 * time.Second
+
+// pushFinalMetrics pushes the current state of all metrics to the Pushgateway
+func pushFinalMetrics(pushgatewayURL, job string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), finalPushTimeout)
+	defer cancel()
+	// The extra labels of the locations are pushed as on /metrics, but not the
+	// observation timestamps of USE_OBSERVATION_TIMESTAMP, which the
+	// Pushgateway rejects
+	return push.New(pushgatewayURL, job).Gatherer(stationLabelGatherer{exporterGatherer}).PushContext(ctx)
+}
+
+// runUpdateLoop calls refresh on every tick of the clock until ctx is done.
+// Errors are logged by the update functions, so they are ignored here.
+func runUpdateLoop(ctx context.Context, clk clock, interval time.Duration, refresh func(context.Context) error) {
+	ticker := clk.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C():
+			_ = refreshSafely(ctx, refresh)
+		}
+	}
+}
+
+// recoverPanic turns a panic into an error in *err, logging it with the stack
+// trace, so a bug in fetch or parse code does not stop the updates. It must
+// be deferred directly.
+func recoverPanic(err *error) {
+	if r := recover(); r != nil {
+		owExporterPanics.Inc()
+		log.Printf("Recovered from panic: %v\n%s", r, debug.Stack())
+		*err = fmt.Errorf("panic: %v", r)
+	}
+}
+
+// refreshSafely calls refresh, recovering from any panic
+func refreshSafely(ctx context.Context, refresh func(context.Context) error) (err error) {
+	defer recoverPanic(&err)
+	return refresh(ctx)
+}
+
+// strictStartup makes every configuration error fatal. With
+// STRICT_STARTUP=false, invalid optional settings are logged and replaced by
+// their defaults so the exporter still comes up.
+var strictStartup = true
+
+// configError reports an invalid configuration value. It exits in strict mode
+// and otherwise logs a warning, leaving the caller to fall back to the default.
+func configError(format string, args ...any) {
+	if strictStartup {
+		log.Fatalf(format, args...)
+	}
+	owConfigErrors.Inc()
+	log.Printf("Warning: "+format, args...)
+}
+
+// getEnvDefault returns the value of the environment variable or the fallback if unset
+func getEnvDefault(key, fallback string) string {
+	if value := os.Getenv(key); value != "" {
+		return value
+	}
+	return fallback
+}
+
+func main() {
+	dashboard := flag.Bool("dashboard", false, "Print a Grafana dashboard for the exporter metrics as JSON and exit")
+	flag.Parse()
+	if *dashboard {
+		// Register the metrics that otherwise depend on the configuration
+		registerUnitMetrics("")
+		histogram := newScrapeDurationHistogram(prometheus.DefBuckets, false)
+		exporterRegistry.MustRegister(histogram)
+		exporterRegistry.MustRegister(newExporterHealth(0, nil).gauge(clk))
+		if err := writeDashboard(os.Stdout, exporterRegistry, histogram); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+
+	owExporterStartTime.SetToCurrentTime()
+
+	// Load environment variables from ENV_FILE, or .env if it exists. An
+	// explicit ENV_FILE must load, it can also hold STRICT_STARTUP so this is
+	// fatal regardless of it.
+	envFile := getEnvDefault("ENV_FILE", ".env")
+	if err := godotenv.Load(envFile); err != nil {
+		if os.Getenv("ENV_FILE") != "" {
+			log.Fatalf("Failed to load ENV_FILE %s: %v", envFile, err)
+		}
+		log.Printf("Warning: %s file not found, using system environment variables: %v", envFile, err)
+	} else {
+		log.Printf("Loaded environment variables from %s", envFile)
+	}
+
+	strict, err := strconv.ParseBool(getEnvDefault("STRICT_STARTUP", "true"))
+	if err != nil {
+		log.Fatalf("Invalid STRICT_STARTUP: %v", err)
+	}
+	strictStartup = strict
+	switch level := getEnvDefault("LOG_LEVEL", "info"); level {
+	case "info":
+	case "debug":
+		debugLogging = true
+	default:
+		configError("LOG_LEVEL must be either info or debug, got %q", level)
+	}
+
+	latitude := os.Getenv("LATITUDE")
+	longitude := os.Getenv("LONGITUDE")
+	units = getEnvDefault("UNITS", "standard")
+	if !validUnits(units) {
+		configError("UNITS must be either standard, imperial, or metric")
+		units = "standard"
+	}
+	apiKey := os.Getenv("OPENWEATHER_API_KEY")
+	exporterPort := os.Getenv("EXPORTER_PORT")
+	cityIDs := os.Getenv("CITY_IDS")
+	locationsValue := os.Getenv("LOCATIONS")
+	locationsPath := os.Getenv("LOCATIONS_FILE")
+
+	// The key is assumed valid until OpenWeather rejects it
+	setAPIKeyValid(true)
+	if apiKey == "" {
+		// Requests fail until the key is set, but /healthz can still be served
+		configError("OPENWEATHER_API_KEY environment variable must be set")
+		setAPIKeyValid(false)
+	}
+	if cityIDs == "" && locationsValue == "" && locationsPath == "" && (latitude == "" || longitude == "") {
+		log.Fatal("LATITUDE and LONGITUDE environment variables must be set unless LOCATIONS, LOCATIONS_FILE, or CITY_IDS is used")
+	}
+	if locationsPath != "" && (locationsValue != "" || cityIDs != "") {
+		log.Fatal("LOCATIONS_FILE cannot be combined with LOCATIONS or CITY_IDS")
+	}
+
+	if exporterPort == "" {
+		exporterPort = "8080"
+	}
+	addr, err := listenAddress(os.Getenv("LISTEN_ADDRESS"), exporterPort)
+	if err != nil {
+		log.Fatal(err)
+	}
+	readHeaderTimeout, err := time.ParseDuration(getEnvDefault("HTTP_READ_HEADER_TIMEOUT", "5s"))
+	if err != nil || readHeaderTimeout < 0 {
+		configError("HTTP_READ_HEADER_TIMEOUT must be a duration such as 5s, got %q", os.Getenv("HTTP_READ_HEADER_TIMEOUT"))
+		readHeaderTimeout = 5 * time.Second
+	}
+	readTimeout, err := time.ParseDuration(getEnvDefault("HTTP_READ_TIMEOUT", "10s"))
+	if err != nil || readTimeout < 0 {
+		configError("HTTP_READ_TIMEOUT must be a duration such as 10s, got %q", os.Getenv("HTTP_READ_TIMEOUT"))
+		readTimeout = 10 * time.Second
+	}
+	writeTimeout, err := time.ParseDuration(getEnvDefault("HTTP_WRITE_TIMEOUT", "30s"))
+	if err != nil || writeTimeout < 0 {
+		configError("HTTP_WRITE_TIMEOUT must be a duration such as 30s, got %q", os.Getenv("HTTP_WRITE_TIMEOUT"))
+		writeTimeout = 30 * time.Second
+	}
+
+	maxIdleConns, err := strconv.Atoi(getEnvDefault("MAX_IDLE_CONNS", "100"))
+	if err != nil || maxIdleConns < 0 {
+		configError("MAX_IDLE_CONNS must be a non-negative number, got %q", os.Getenv("MAX_IDLE_CONNS"))
+		maxIdleConns = 100
+	}
+	if limit := os.Getenv("MAX_RESPONSE_BYTES"); limit != "" {
+		n, err := strconv.ParseInt(limit, 10, 64)
+		if err != nil || n < 0 {
+			configError("MAX_RESPONSE_BYTES must be a non-negative number of bytes, got %q", limit)
+		} else {
+			maxResponseBytes = n
+		}
+	}
+	if retries := os.Getenv("DNS_RETRIES"); retries != "" {
+		n, err := strconv.Atoi(retries)
+		if err != nil || n < 0 {
+			configError("DNS_RETRIES must be a non-negative number, got %q", retries)
+		} else {
+			dnsRetries = n
+		}
+	}
+	if delay := os.Getenv("DNS_RETRY_DELAY"); delay != "" {
+		d, err := time.ParseDuration(delay)
+		if err != nil || d < 0 {
+			configError("DNS_RETRY_DELAY must be a duration such as 500ms, got %q", delay)
+		} else {
+			dnsRetryDelay = d
+		}
+	}
+	idleConnTimeout, err := time.ParseDuration(getEnvDefault("IDLE_CONN_TIMEOUT", "90s"))
+	if err != nil || idleConnTimeout < 0 {
+		configError("IDLE_CONN_TIMEOUT must be a duration such as 90s, got %q", os.Getenv("IDLE_CONN_TIMEOUT"))
+		idleConnTimeout = 90 * time.Second
+	}
+	httpClient, err = newHTTPClient(os.Getenv("OPENWEATHER_CLIENT_CERT"), os.Getenv("OPENWEATHER_CLIENT_KEY"), maxIdleConns, idleConnTimeout)
+	if err != nil {
+		log.Fatal(err)
+	}
+	// Without a certificate the client cannot fail
+	thirdPartyHTTPClient, _ = newHTTPClient("", "", maxIdleConns, idleConnTimeout)
+	tier := getEnvDefault("OPENWEATHER_TIER", "free")
+	if tierURL, ok := tierBaseURLs[tier]; ok {
+		proBaseURL = tierURL
+	} else {
+		configError("OPENWEATHER_TIER must be either free or pro, got %q", tier)
+		tier = "free"
+	}
+	// An explicit base URL wins over the tier, for all products
+	if baseURL := os.Getenv("OPENWEATHER_BASE_URL"); baseURL != "" {
+		apiBaseURL, err = parseBaseURL(baseURL)
+		if err != nil {
+			log.Fatal(err)
+		}
+		proBaseURL = apiBaseURL
+	}
+	if path := os.Getenv("AIR_POLLUTION_PATH"); path != "" {
+		if !strings.HasPrefix(path, "/") {
+			log.Fatalf("AIR_POLLUTION_PATH must start with /, got %q", path)
+		}
+		airPollutionPath = path
+	}
+	if fallback := os.Getenv("AIR_POLLUTION_FALLBACK_URL"); fallback != "" {
+		if _, err := parseBaseURL(fallback); err != nil || !strings.Contains(fallback, "{lat}") || !strings.Contains(fallback, "{lon}") {
+			configError("AIR_POLLUTION_FALLBACK_URL must be an http or https URL containing {lat} and {lon}, got %q", fallback)
+		} else {
+			airPollutionFallbackURL = fallback
+		}
+	}
+	startupTimeout, err := time.ParseDuration(getEnvDefault("STARTUP_TIMEOUT", "15s"))
+	if err != nil || startupTimeout <= 0 {
+		configError("STARTUP_TIMEOUT must be a positive duration such as 15s, got %q", os.Getenv("STARTUP_TIMEOUT"))
+		startupTimeout = 15 * time.Second
+	}
+	breakerThreshold, err := strconv.Atoi(getEnvDefault("CIRCUIT_BREAKER_THRESHOLD", "5"))
+	if err != nil {
+		configError("Invalid CIRCUIT_BREAKER_THRESHOLD: %v", err)
+		breakerThreshold = 5
+	}
+	breakerCooldown, err := time.ParseDuration(getEnvDefault("CIRCUIT_BREAKER_COOLDOWN", "10m"))
+	if err != nil {
+		configError("Invalid CIRCUIT_BREAKER_COOLDOWN: %v", err)
+		breakerCooldown = 10 * time.Minute
+	}
+	breakerMaxCooldown, err := time.ParseDuration(getEnvDefault("CIRCUIT_BREAKER_MAX_COOLDOWN", "1h"))
+	if err != nil {
+		configError("Invalid CIRCUIT_BREAKER_MAX_COOLDOWN: %v", err)
+		breakerMaxCooldown = time.Hour
+	}
+	if breakerMaxCooldown < breakerCooldown {
+		configError("CIRCUIT_BREAKER_MAX_COOLDOWN must not be shorter than CIRCUIT_BREAKER_COOLDOWN")
+		breakerMaxCooldown = breakerCooldown
+	}
+	scrapeInterval, err := time.ParseDuration(getEnvDefault("SCRAPE_INTERVAL", "5m"))
+	if err != nil || scrapeInterval < time.Minute {
+		configError("SCRAPE_INTERVAL must be a duration of at least 1m, got %q", os.Getenv("SCRAPE_INTERVAL"))
+		scrapeInterval = 5 * time.Minute
+	}
+	owScrapeInterval.Set(scrapeInterval.Seconds())
+	successWindow, err := time.ParseDuration(getEnvDefault("SCRAPE_SUCCESS_WINDOW", "1h"))
+	if err != nil || successWindow <= 0 {
+		configError("SCRAPE_SUCCESS_WINDOW must be a positive duration such as 1h, got %q", os.Getenv("SCRAPE_SUCCESS_WINDOW"))
+		successWindow = time.Hour
+	}
+	scrapeSuccess = newSuccessRatio(successWindow)
+	warmupPeriod, err := time.ParseDuration(getEnvDefault("WARMUP_PERIOD", scrapeInterval.String()))
+	if err != nil || warmupPeriod < 0 {
+		configError("WARMUP_PERIOD must be a duration such as 5m, got %q", os.Getenv("WARMUP_PERIOD"))
+		warmupPeriod = scrapeInterval
+	}
+	var maxMetricAge time.Duration
+	if age := os.Getenv("MAX_METRIC_AGE"); age != "" {
+		d, err := time.ParseDuration(age)
+		if err != nil || d <= 0 {
+			configError("MAX_METRIC_AGE must be a positive duration such as 1h, got %q", age)
+		} else {
+			maxMetricAge = d
+		}
+	}
+	nativeHistograms, err := strconv.ParseBool(getEnvDefault("NATIVE_HISTOGRAMS", "false"))
+	if err != nil {
+		configError("Invalid NATIVE_HISTOGRAMS: %v", err)
+	}
+	scrapeDurationBuckets := prometheus.DefBuckets
+	if value := os.Getenv("SCRAPE_DURATION_BUCKETS"); value != "" {
+		buckets, err := parseBuckets(value)
+		if err != nil {
+			configError("Invalid SCRAPE_DURATION_BUCKETS: %v", err)
+		} else {
+			scrapeDurationBuckets = buckets
+		}
+	}
+	latencyMetric := getEnvDefault("LATENCY_METRIC", "histogram")
+	if latencyMetric != "histogram" && latencyMetric != "summary" {
+		configError("LATENCY_METRIC must be histogram or summary, got %q", latencyMetric)
+		latencyMetric = "histogram"
+	}
+	if precision := os.Getenv("METRIC_PRECISION"); precision != "" {
+		n, err := strconv.Atoi(precision)
+		if err != nil || n < 0 {
+			configError("METRIC_PRECISION must be a non-negative number of decimal places, got %q", precision)
+		} else {
+			metricPrecision = n
+		}
+	}
+	solarEnabled, err = strconv.ParseBool(getEnvDefault("ENABLE_SOLAR", "false"))
+	if err != nil {
+		configError("Invalid ENABLE_SOLAR: %v", err)
+	}
+	forecastEnabled, err = strconv.ParseBool(getEnvDefault("ENABLE_FORECAST", "false"))
+	if err != nil {
+		configError("Invalid ENABLE_FORECAST: %v", err)
+	}
+	if points := os.Getenv("FORECAST_POINTS"); points != "" {
+		n, err := strconv.Atoi(points)
+		if err != nil || n < 1 || n > 40 {
+			configError("FORECAST_POINTS must be a number between 1 and 40, got %q", points)
+		} else {
+			forecastPoints = n
+		}
+	}
+	daily16Enabled, err = strconv.ParseBool(getEnvDefault("ENABLE_DAILY16", "false"))
+	if err != nil {
+		configError("Invalid ENABLE_DAILY16: %v", err)
+	}
+	if days := os.Getenv("DAILY16_DAYS"); days != "" {
+		n, err := strconv.Atoi(days)
+		if err != nil || n < 1 || n > 16 {
+			configError("DAILY16_DAYS must be a number between 1 and 16, got %q", days)
+		} else {
+			daily16Days = n
+		}
+	}
+	overviewEnabled, err = strconv.ParseBool(getEnvDefault("ENABLE_OVERVIEW", "false"))
+	if err != nil {
+		configError("Invalid ENABLE_OVERVIEW: %v", err)
+	}
+	if filter := os.Getenv("CONDITION_FILTER"); filter != "" {
+		weatherConditionFilter, err = parseConditionFilter(filter)
+		if err != nil {
+			configError("Invalid CONDITION_FILTER: %v", err)
+		}
+	}
+	skipZeroOptional, err = strconv.ParseBool(getEnvDefault("SKIP_ZERO_OPTIONAL", "false"))
+	if err != nil {
+		configError("Invalid SKIP_ZERO_OPTIONAL: %v", err)
+	}
+	if threshold := os.Getenv("HUMIDITY_THRESHOLD"); threshold != "" {
+		t, err := strconv.ParseFloat(threshold, 64)
+		if err != nil || t < 0 || t > 100 {
+			configError("HUMIDITY_THRESHOLD must be a percentage between 0 and 100, got %q", threshold)
+		} else {
+			humidityThreshold = t
+		}
+	}
+	if alpha := os.Getenv("SMOOTHING_ALPHA"); alpha != "" {
+		a, err := strconv.ParseFloat(alpha, 64)
+		if err != nil || a <= 0 || a > 1 {
+			configError("SMOOTHING_ALPHA must be a number greater than 0 and at most 1, got %q", alpha)
+		} else {
+			smoothingAlpha = a
+		}
+	}
+	if bearing := os.Getenv("TARGET_BEARING"); bearing != "" {
+		b, err := strconv.ParseFloat(bearing, 64)
+		if err != nil || b < 0 || b >= 360 {
+			configError("TARGET_BEARING must be a number of degrees from 0 to less than 360, got %q", bearing)
+		} else {
+			targetBearing = b
+		}
+	}
+	if weights := os.Getenv("SCORE_WEIGHTS"); weights != "" {
+		w, err := parseScoreWeights(weights)
+		if err != nil {
+			configError("Invalid SCORE_WEIGHTS: %v", err)
+		} else {
+			scoreWeights = w
+		}
+	}
+	if precision := os.Getenv("COORD_PRECISION"); precision != "" {
+		n, err := strconv.Atoi(precision)
+		if err != nil || n < 0 {
+			configError("COORD_PRECISION must be a non-negative number of decimal places, got %q", precision)
+		} else {
+			coordPrecision = n
+		}
+	}
+	if samples := os.Getenv("HISTORY_SAMPLES"); samples != "" {
+		n, err := strconv.Atoi(samples)
+		if err != nil || n < 2 {
+			configError("HISTORY_SAMPLES must be a number of at least 2, got %q", samples)
+		} else {
+			historySamples = n
+		}
+	}
+	fetchConcurrency := 4
+	if concurrency := os.Getenv("FETCH_CONCURRENCY"); concurrency != "" {
+		n, err := strconv.Atoi(concurrency)
+		if err != nil || n < 1 {
+			configError("FETCH_CONCURRENCY must be a number of at least 1, got %q", concurrency)
+		} else {
+			fetchConcurrency = n
+		}
+	}
+	errorLogEvery, err := strconv.Atoi(getEnvDefault("ERROR_LOG_EVERY", "12"))
+	if err != nil || errorLogEvery < 0 {
+		configError("ERROR_LOG_EVERY must be a non-negative number, got %q", os.Getenv("ERROR_LOG_EVERY"))
+		errorLogEvery = 12
+	}
+	errorLogInterval, err := time.ParseDuration(getEnvDefault("ERROR_LOG_INTERVAL", "1h"))
+	if err != nil || errorLogInterval < 0 {
+		configError("ERROR_LOG_INTERVAL must be a duration such as 1h, got %q", os.Getenv("ERROR_LOG_INTERVAL"))
+		errorLogInterval = time.Hour
+	}
+	failures = newFailureLog(errorLogEvery, errorLogInterval)
+	if tz := os.Getenv("USAGE_TIMEZONE"); tz != "" {
+		loc, err := time.LoadLocation(tz)
+		if err != nil {
+			configError("Invalid USAGE_TIMEZONE: %v", err)
+		} else {
+			usageTimezone = loc
+		}
+	}
+	persistCounters, err = strconv.ParseBool(getEnvDefault("PERSIST_COUNTERS", "false"))
+	if err != nil {
+		configError("Invalid PERSIST_COUNTERS: %v", err)
+	}
+	cacheFile = os.Getenv("CACHE_FILE")
+	if persistCounters && cacheFile == "" {
+		configError("PERSIST_COUNTERS requires CACHE_FILE to be set")
+		persistCounters = false
+	}
+	if cacheFile != "" {
+		state, err := loadCache(cacheFile)
+		if err != nil {
+			configError("%v", err)
+		}
+		if state.Usage != nil {
+			restoreUsage(*state.Usage, clk.Now())
+		}
+		if persistCounters {
+			if err := restoreCounters(state.Counters); err != nil {
+				configError("%v", err)
+			}
+		}
+	}
+	if graphiteAddress := os.Getenv("GRAPHITE_ADDRESS"); graphiteAddress != "" {
+		graphite, err = newGraphiteWriter(graphiteAddress, getEnvDefault("GRAPHITE_PREFIX", "openweather"))
+		if err != nil {
+			configError("%v", err)
+		}
+	}
+	if influxURL := os.Getenv("INFLUXDB_URL"); influxURL != "" {
+		influx, err = newInfluxWriter(influxURL, os.Getenv("INFLUXDB_TOKEN"), os.Getenv("INFLUXDB_ORG"), os.Getenv("INFLUXDB_BUCKET"))
+		if err != nil {
+			configError("%v", err)
+		}
+	}
+	disableGoCollectors, err := strconv.ParseBool(getEnvDefault("DISABLE_GO_COLLECTORS", "false"))
+	if err != nil {
+		configError("Invalid DISABLE_GO_COLLECTORS: %v", err)
+	}
+	if disableGoCollectors {
+		// Leave out the default registry with the Go and process collectors
+		exporterGatherer = exporterRegistry
+		metricsGatherer = stationLabelGatherer{exporterGatherer}
+	}
+	var otlp otlpExporter
+	if otlpEndpoint := os.Getenv("OTLP_ENDPOINT"); otlpEndpoint != "" {
+		if newOTLPExporter == nil {
+			configError("OTLP_ENDPOINT requires a binary built with -tags otlp")
+		} else if otlp, err = newOTLPExporter(context.Background(), otlpEndpoint, metricsGatherer); err != nil {
+			configError("%v", err)
+		}
+	}
+	debugEndpoints, err = strconv.ParseBool(getEnvDefault("ENABLE_DEBUG_ENDPOINTS", "false"))
+	if err != nil {
+		configError("Invalid ENABLE_DEBUG_ENDPOINTS: %v", err)
+	}
+	useObservationTimestamp, err := strconv.ParseBool(getEnvDefault("USE_OBSERVATION_TIMESTAMP", "false"))
+	if err != nil {
+		configError("Invalid USE_OBSERVATION_TIMESTAMP: %v", err)
+	}
+	if useObservationTimestamp {
+		metricsGatherer = observationTimestampGatherer{metricsGatherer}
+	}
+	if latencyMetric == "summary" {
+		summary := newLatencySummary()
+		exporterRegistry.MustRegister(summary)
+		owScrapeDuration = summary
+	} else {
+		histogram := newScrapeDurationHistogram(scrapeDurationBuckets, nativeHistograms)
+		exporterRegistry.MustRegister(histogram)
+		owScrapeDuration = histogram
+	}
+
+	mode := "coordinates"
+	if cityIDs != "" {
+		mode = "city_ids"
+	} else if locationsPath != "" {
+		mode = "locations_file"
+	}
+	owExporterConfigInfo.WithLabelValues(
+		units,
+		scrapeInterval.String(),
+		tier,
+		mode,
+		latencyMetric,
+		strconv.FormatBool(forecastEnabled),
+		strconv.FormatBool(daily16Enabled),
+		strconv.FormatBool(solarEnabled),
+		strconv.FormatBool(overviewEnabled),
+		strconv.FormatBool(airPollutionFallbackURL != ""),
+	).Set(1)
+
+	var update func(ctx context.Context) (int, error)
+	if cityIDs != "" {
+		ids, err := parseCityIDs(cityIDs)
+		if err != nil {
+			log.Fatalf("Invalid CITY_IDS: %v", err)
+		}
+		groupURL := fmt.Sprintf("%s/data/2.5/group?id=%s&appid=%s&units=%s&mode=json", apiBaseURL, strings.Join(ids, ","), apiKey, units)
+		update = func(ctx context.Context) (int, error) {
+			return updateGroupMetrics(ctx, groupURL, apiKey)
+		}
+		registerUnitMetrics(units)
+	} else if locationsPath != "" {
+		file := &locationsFile{path: locationsPath, apiKey: apiKey}
+		if err := file.reload(true); err != nil {
+			log.Fatal(err)
+		}
+		// Locations may change units on reload, so use the generic help text
+		registerUnitMetrics("")
+		update = func(ctx context.Context) (int, error) {
+			if err := file.reload(false); err != nil {
+				log.Printf("Error reloading locations, keeping the previous ones: %v", err)
+			}
+			return updateLocations(ctx, file.current(), fetchConcurrency)
+		}
+
+		hup := make(chan os.Signal, 1)
+		signal.Notify(hup, syscall.SIGHUP)
+		go func() {
+			for range hup {
+				if err := file.reload(true); err != nil {
+					log.Printf("Error reloading locations, keeping the previous ones: %v", err)
+				}
+			}
+		}()
+	} else {
+		locations := []location{{
+			Latitude:  latitude,
+			Longitude: longitude,
+			Name:      os.Getenv("LOCATION_NAME"),
+		}}
+		if locationsValue != "" {
+			locations, err = parseLocations(locationsValue)
+			if err != nil {
+				log.Fatalf("Invalid LOCATIONS: %v", err)
+			}
+		} else if err := locations[0].normalizeCoordinates(); err != nil {
+			log.Fatalf("Invalid LATITUDE or LONGITUDE: %v", err)
+		}
+		metricUnits := units
+		for i := range locations {
+			if locations[i].Units == "" {
+				locations[i].Units = units
+			}
+			if locations[i].Units != units {
+				metricUnits = ""
+			}
+			locations[i].buildURLs(apiKey)
+			addLocationStatus(locations[i])
+		}
+		registerUnitMetrics(metricUnits)
+		update = func(ctx context.Context) (int, error) {
+			return updateLocations(ctx, locations, fetchConcurrency)
+		}
+	}
+
+	breaker := newCircuitBreaker(breakerThreshold, breakerCooldown, breakerMaxCooldown)
+	// Like the health, one failed cycle between successful ones is tolerated
+	ready := newReadiness(clk.Now(), warmupPeriod, 2*scrapeInterval)
+	health := newExporterHealth(2*scrapeInterval, breaker)
+	exporterRegistry.MustRegister(health.gauge(clk))
+	refresh := func(ctx context.Context) error {
+		if !breaker.allow(clk.Now()) {
+			return nil
+		}
+		active, err := update(ctx)
+		owActiveStations.Set(float64(active))
+		breaker.record(active > 0, clk.Now())
+		ready.record(active > 0, clk.Now())
+		health.record(active > 0, clk.Now())
+		if graphite != nil && active > 0 {
+			if err := graphite.write(ctx); err != nil {
+				log.Printf("Error sending metrics to Graphite: %v", err)
+			}
+		}
+		if influx != nil {
+			if err := influx.flush(ctx); err != nil {
+				log.Printf("Error writing to InfluxDB: %v", err)
+			}
+		}
+		if otlp != nil && active > 0 {
+			if err := otlp.push(ctx); err != nil {
+				log.Printf("Error sending metrics to OTLP endpoint: %v", err)
+			}
+		}
+		persistCache()
+		return err
+	}
+
+	// Initial fetch, bounded so the server starts even if OpenWeather hangs
+	startupCtx, cancelStartup := context.WithTimeout(context.Background(), startupTimeout)
+	// Errors are already logged by the update functions
+	_ = refreshSafely(startupCtx, refresh)
+	if errors.Is(startupCtx.Err(), context.DeadlineExceeded) {
+		log.Printf("Warning: initial fetch did not finish within %s, starting anyway", startupTimeout)
+	}
+	cancelStartup()
+
+	// Update metrics every SCRAPE_INTERVAL, by default 5 minutes. Each tick
+	// costs 2 API calls per location plus one per enabled optional endpoint,
+	// see the API Rate Limits section of the README.
+	go runUpdateLoop(context.Background(), clk, scrapeInterval, refresh)
+	if maxMetricAge > 0 {
+		go runStalenessCheck(context.Background(), clk, maxMetricAge)
+	}
+
+	// Set up HTTP server for metrics endpoint
+	metricsHandler := promhttp.HandlerFor(metricsGatherer, promhttp.HandlerOpts{})
+	if !disableGoCollectors {
+		// Adds the promhttp_metric_handler_* metrics like promhttp.Handler
+		metricsHandler = promhttp.InstrumentMetricHandler(prometheus.DefaultRegisterer, metricsHandler)
+	}
+	http.Handle("/metrics", metricsHandler)
+	http.HandleFunc("/healthz", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("ok\n"))
+	})
+	http.HandleFunc("/readyz", ready.handler(clk))
+	http.HandleFunc("/status", handleStatus)
+	if debugEndpoints {
+		http.HandleFunc("/debug/raw", handleDebugRaw)
+	}
 	http.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
 		w.Write([]byte(`<html>
 			<head><title>OpenWeather Exporter</title></head>
@@ -412,6 +2607,45 @@ func main() {
 		</html>`))
 	})
 
-	log.Printf("Starting OpenWeather exporter on port %s", exporterPort)
-	log.Fatal(http.ListenAndServe(":"+exporterPort, nil))
+	// Bound how long a client may take to send a request or read the response,
+	// so slow or stalled connections cannot pile up
+	server := &http.Server{
+		Addr:              addr,
+		ReadHeaderTimeout: readHeaderTimeout,
+		ReadTimeout:       readTimeout,
+		WriteTimeout:      writeTimeout,
+	}
+	go func() {
+		log.Printf("Starting OpenWeather exporter on %s", addr)
+		if err := server.ListenAndServe(); !errors.Is(err, http.ErrServerClosed) {
+			log.Fatal(err)
+		}
+	}()
+
+	// Wait for a termination signal, then shut down gracefully
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	<-ctx.Done()
+	log.Printf("Shutting down OpenWeather exporter")
+
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	if err := server.Shutdown(shutdownCtx); err != nil {
+		log.Printf("Error shutting down HTTP server: %v", err)
+	}
+
+	if pushgatewayURL := os.Getenv("PUSHGATEWAY_URL"); pushgatewayURL != "" {
+		job := getEnvDefault("PUSHGATEWAY_JOB", "openweather_exporter")
+		if err := pushFinalMetrics(pushgatewayURL, job); err != nil {
+			log.Printf("Error pushing final metrics to Pushgateway: %v", err)
+		} else {
+			log.Printf("Pushed final metrics to Pushgateway at %s", pushgatewayURL)
+		}
+	}
+	if otlp != nil {
+		if err := otlp.shutdown(shutdownCtx); err != nil {
+			log.Printf("Error shutting down OTLP exporter: %v", err)
+		}
+	}
+	persistCache()
 }
@@ -0,0 +1,593 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/prometheus/common/expfmt"
+)
+
+func TestFetchJSONFixtures(t *testing.T) {
+	server := newFixtureServer(t)
+
+	tests := []struct {
+		path string
+		v    any
+	}{
+		{"/data/2.5/weather", &WeatherResponse{}},
+		{"/data/2.5/group", &GroupResponse{}},
+		{"/data/2.5/air_pollution", &AirPollutionResponse{}},
+		{"/data/2.5/forecast", &ForecastResponse{}},
+		{"/data/2.5/forecast/daily", &DailyForecastResponse{}},
+		{"/data/2.5/solar_radiation", &SolarRadiationResponse{}},
+		{"/data/3.0/onecall/overview", &WeatherOverviewResponse{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.path, func(t *testing.T) {
+			if err := fetchJSON(context.Background(), "test", "test", server.URL+tt.path, tt.v); err != nil {
+				t.Fatalf("fetchJSON(%s) = %v", tt.path, err)
+			}
+		})
+	}
+}
+
+func TestRequestJSONErrors(t *testing.T) {
+	server := newFixtureServer(t)
+
+	previousLimit := maxResponseBytes
+	maxResponseBytes = 64
+	t.Cleanup(func() { maxResponseBytes = previousLimit })
+
+	tests := []struct {
+		name    string
+		handler http.HandlerFunc
+		target  any
+		reason  string
+	}{
+		{
+			name: "status",
+			handler: func(w http.ResponseWriter, r *http.Request) {
+				http.Error(w, `{"cod":401,"message":"Invalid API key"}`, http.StatusUnauthorized)
+			},
+			target: new(*StatusError),
+			reason: "status",
+		},
+		{
+			name: "content type",
+			handler: func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("Content-Type", "text/html")
+				w.Write([]byte("<html>proxy login</html>"))
+			},
+			target: new(*DecodeError),
+			reason: "decode",
+		},
+		{
+			name: "too large",
+			handler: func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("Content-Type", "application/json")
+				w.Write([]byte(`{"name":"` + strings.Repeat("x", 100) + `"}`))
+			},
+			target: new(*TooLargeError),
+			reason: "too_large",
+		},
+		{
+			name: "decode",
+			handler: func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("Content-Type", "application/json")
+				w.Write([]byte(`{"main":{"temp":"warm"}}`))
+			},
+			target: new(*DecodeError),
+			reason: "decode",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := "/" + strings.ReplaceAll(tt.name, " ", "_")
+			server.handle(path, tt.handler)
+
+			var weather WeatherResponse
+			err := fetchJSON(context.Background(), "test", "weather", server.URL+path, &weather)
+			if err == nil {
+				t.Fatal("fetchJSON succeeded, want an error")
+			}
+			if !errors.As(err, tt.target) {
+				t.Errorf("fetchJSON error = %T %v, want %T", err, err, tt.target)
+			}
+			if reason := errorReason(err); reason != tt.reason {
+				t.Errorf("errorReason = %q, want %q", reason, tt.reason)
+			}
+		})
+	}
+}
+
+func TestNewHTTPClientIdleConns(t *testing.T) {
+	tests := []struct {
+		maxIdleConns int
+		perHost      int
+	}{
+		{100, 100},
+		{0, math.MaxInt},
+	}
+	for _, tt := range tests {
+		client, err := newHTTPClient("", "", tt.maxIdleConns, time.Minute)
+		if err != nil {
+			t.Fatal(err)
+		}
+		transport := client.Transport.(*http.Transport)
+		if transport.MaxIdleConns != tt.maxIdleConns || transport.MaxIdleConnsPerHost != tt.perHost {
+			t.Errorf("newHTTPClient(%d) MaxIdleConns = %d, MaxIdleConnsPerHost = %d, want %d, %d", tt.maxIdleConns, transport.MaxIdleConns, transport.MaxIdleConnsPerHost, tt.maxIdleConns, tt.perHost)
+		}
+	}
+}
+
+func TestSetWeatherMetricsTemperatureUnits(t *testing.T) {
+	server := newFixtureServer(t)
+	weather, err := fetchWeatherData(context.Background(), server.URL+"/data/2.5/weather", "standard")
+	if err != nil {
+		t.Fatal(err)
+	}
+	station := strconv.Itoa(weather.ID)
+	t.Cleanup(func() { deleteStationSeries(station) })
+
+	// The fixture reports 288.15 K
+	if got := testutil.ToFloat64(owWeatherTempCelsius.WithLabelValues(station)); math.Abs(got-15) > tolerance {
+		t.Errorf("ow_weather_temp_celsius = %v, want 15", got)
+	}
+	if got := testutil.ToFloat64(owWeatherTempFahrenheit.WithLabelValues(station)); math.Abs(got-59) > tolerance {
+		t.Errorf("ow_weather_temp_fahrenheit = %v, want 59", got)
+	}
+}
+
+func TestSetWeatherMetricsSunTimes(t *testing.T) {
+	body, err := os.ReadFile(filepath.Join("testdata", "weather.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name            string
+		sunrise, sunset int64
+		want            int
+	}{
+		{"regular day", 1760619600, 1760660400, 1},
+		{"polar day", 0, 0, 0},
+		{"sunset missing", 1760619600, 0, 0},
+	}
+	var station string
+	for _, tt := range tests {
+		var weather WeatherResponse
+		if err := json.Unmarshal(body, &weather); err != nil {
+			t.Fatal(err)
+		}
+		weather.Sys.Sunrise, weather.Sys.Sunset = tt.sunrise, tt.sunset
+		station = setWeatherMetrics(&weather, "standard")
+		for _, vec := range []*prometheus.GaugeVec{owWeatherSecondsToSunrise, owWeatherSecondsToSunset, owWeatherSunTimesInfo} {
+			if n := stationSeries(t, vec, station); n != tt.want {
+				t.Errorf("%s: %d series, want %d", tt.name, n, tt.want)
+			}
+		}
+	}
+	deleteStationSeries(station)
+}
+
+func TestRunUpdateLoop(t *testing.T) {
+	fake := newFakeClock(time.Date(2026, 1, 1, 12, 0, 0, 0, time.UTC))
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	refreshed := make(chan time.Time, 1)
+	calls := 0
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		runUpdateLoop(ctx, fake, 5*time.Minute, func(context.Context) error {
+			calls++
+			refreshed <- fake.Now()
+			if calls == 1 {
+				panic("first refresh fails")
+			}
+			return nil
+		})
+	}()
+	ticker := fake.waitTicker(t)
+
+	// The first refresh panics, the loop must keep running
+	fake.advance(5 * time.Minute)
+	select {
+	case <-refreshed:
+	case <-time.After(time.Second):
+		t.Fatal("not refreshed after the interval")
+	}
+	fake.advance(4 * time.Minute)
+	select {
+	case <-refreshed:
+		t.Fatal("refreshed before the interval elapsed")
+	default:
+	}
+	fake.advance(time.Minute)
+	select {
+	case at := <-refreshed:
+		if want := time.Date(2026, 1, 1, 12, 10, 0, 0, time.UTC); !at.Equal(want) {
+			t.Errorf("refreshed at %s, want %s", at, want)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("not refreshed after the interval")
+	}
+
+	cancel()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("update loop did not stop when the context was canceled")
+	}
+	if !ticker.stopped() {
+		t.Error("ticker not stopped when the update loop ended")
+	}
+}
+
+// serveWeatherByLatitude serves the weather fixture with a station ID derived
+// from the requested latitude, so every location has its own station
+func serveWeatherByLatitude(t *testing.T) http.HandlerFunc {
+	fixture, err := os.ReadFile(filepath.Join("testdata", "weather.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return func(w http.ResponseWriter, r *http.Request) {
+		var weather map[string]any
+		if err := json.Unmarshal(fixture, &weather); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		lat, _ := strconv.ParseFloat(r.URL.Query().Get("lat"), 64)
+		weather["id"] = 1000 + int(lat)
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(weather)
+	}
+}
+
+func TestUpdateLocationsConcurrent(t *testing.T) {
+	server := newFixtureServer(t)
+	server.handle("/data/2.5/weather", serveWeatherByLatitude(t))
+
+	const concurrency = 4
+	var inFlight, maxInFlight atomic.Int32
+	server.handle("/data/2.5/air_pollution", func(w http.ResponseWriter, r *http.Request) {
+		n := inFlight.Add(1)
+		defer inFlight.Add(-1)
+		for {
+			highest := maxInFlight.Load()
+			if n <= highest || maxInFlight.CompareAndSwap(highest, n) {
+				break
+			}
+		}
+		time.Sleep(5 * time.Millisecond)
+		serveFixture(w, "air_pollution.json")
+	})
+
+	var locations []location
+	for i := range 8 {
+		loc := location{Latitude: strconv.Itoa(i), Longitude: "0", Units: "metric"}
+		loc.buildURLs("secret")
+		locations = append(locations, loc)
+		t.Cleanup(func() { removeStation(strconv.Itoa(1000 + i)) })
+	}
+
+	active, err := updateLocations(context.Background(), locations, concurrency)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if active != len(locations) {
+		t.Errorf("updateLocations updated %d locations, want %d", active, len(locations))
+	}
+	if n := maxInFlight.Load(); n > concurrency {
+		t.Errorf("%d locations were updated at once, want at most %d", n, concurrency)
+	}
+	for i := range locations {
+		station := strconv.Itoa(1000 + i)
+		if n := stationSeries(t, owWeatherHumidity, station); n != 1 {
+			t.Errorf("station %s has %d ow_weather_humidity series, want 1", station, n)
+		}
+		if n := stationSeries(t, owAirPollutionAQI, station); n != 1 {
+			t.Errorf("station %s has %d ow_air_pollution_aqi series, want 1", station, n)
+		}
+	}
+}
+
+func TestUpdateLocationsSharedStationCondition(t *testing.T) {
+	server := newFixtureServer(t)
+	fixture, err := os.ReadFile(filepath.Join("testdata", "weather.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Both locations resolve to the same station, which reports rain at one
+	// and clear sky at the other
+	const station = "2000"
+	server.handle("/data/2.5/weather", func(w http.ResponseWriter, r *http.Request) {
+		var weather map[string]any
+		if err := json.Unmarshal(fixture, &weather); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		weather["id"] = 2000
+		condition := map[string]any{"id": 800, "main": "Clear", "description": "clear sky", "icon": "01d"}
+		if r.URL.Query().Get("lat") == "1" {
+			condition = map[string]any{"id": 500, "main": "Rain", "description": "light rain", "icon": "10d"}
+		}
+		weather["weather"] = []any{condition}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(weather)
+	})
+	t.Cleanup(func() { removeStation(station) })
+
+	var locations []location
+	for _, lat := range []string{"1", "2"} {
+		loc := location{Latitude: lat, Longitude: "0", Units: "metric"}
+		loc.buildURLs("secret")
+		locations = append(locations, loc)
+	}
+	for range 50 {
+		if _, err := updateLocations(context.Background(), locations, len(locations)); err != nil {
+			t.Fatal(err)
+		}
+		if n := stationSeries(t, owWeatherCondition, station); n != 1 {
+			t.Fatalf("station %s has %d ow_weather_condition series, want 1", station, n)
+		}
+	}
+}
+
+func TestSetWeatherMetricsSharedStationCondition(t *testing.T) {
+	const station = "2001"
+	t.Cleanup(func() { removeStation(station) })
+	var responses []*WeatherResponse
+	for _, body := range []string{
+		`{"id":2001,"weather":[{"id":500,"main":"Rain","description":"light rain","icon":"10d"}]}`,
+		`{"id":2001,"weather":[{"id":800,"main":"Clear","description":"clear sky","icon":"01d"}]}`,
+	} {
+		var weather WeatherResponse
+		if err := json.Unmarshal([]byte(body), &weather); err != nil {
+			t.Fatal(err)
+		}
+		responses = append(responses, &weather)
+	}
+	for range 1000 {
+		var wg sync.WaitGroup
+		start := make(chan struct{})
+		for _, weather := range responses {
+			wg.Go(func() {
+				<-start
+				setWeatherMetrics(weather, "metric")
+			})
+		}
+		close(start)
+		wg.Wait()
+		if n := stationSeries(t, owWeatherCondition, station); n != 1 {
+			t.Fatalf("station %s has %d ow_weather_condition series, want 1", station, n)
+		}
+	}
+}
+
+func TestFetchAirPollutionDataSource(t *testing.T) {
+	server := newFixtureServer(t)
+	const station = "air-source-test"
+	t.Cleanup(func() { removeStation(station) })
+
+	var empty atomic.Bool
+	server.handle("/data/2.5/air_pollution", func(w http.ResponseWriter, r *http.Request) {
+		if empty.Load() {
+			w.Header().Set("Content-Type", "application/json")
+			w.Write([]byte(`{"coord":{"lon":0,"lat":0},"list":[]}`))
+			return
+		}
+		serveFixture(w, "air_pollution.json")
+	})
+	server.handle("/fallback", func(w http.ResponseWriter, r *http.Request) {
+		serveFixture(w, "air_pollution.json")
+	})
+
+	for _, tt := range []struct {
+		empty  bool
+		source string
+	}{
+		{false, "openweather"},
+		{true, "fallback"},
+		{false, "openweather"},
+	} {
+		empty.Store(tt.empty)
+		if _, err := fetchAirPollutionData(context.Background(), server.URL+"/data/2.5/air_pollution", server.URL+"/fallback", station); err != nil {
+			t.Fatal(err)
+		}
+		for _, c := range []prometheus.Collector{owAirPollutionAQI, owAirPollutionPM25} {
+			if sources := stationSources(t, c, station); !slices.Equal(sources, []string{tt.source}) {
+				t.Errorf("sources of station %s = %v, want [%s]", station, sources, tt.source)
+			}
+		}
+	}
+}
+
+func TestFetchAirPollutionDataFallbackBookkeeping(t *testing.T) {
+	server := newFixtureServer(t)
+	const station = "air-fallback-test"
+	t.Cleanup(func() { removeStation(station) })
+
+	server.handle("/data/2.5/air_pollution", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"coord":{"lon":0,"lat":0},"list":[]}`))
+	})
+	server.handle("/fallback", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusUnauthorized)
+	})
+
+	setAPIKeyValid(true)
+	before := currentUsage().DayRequests
+	if _, err := fetchAirPollutionData(context.Background(), server.URL+"/data/2.5/air_pollution", server.URL+"/fallback", station); err == nil {
+		t.Fatal("fetchAirPollutionData succeeded despite the 401 of the fallback")
+	}
+	if got := currentUsage().DayRequests - before; got != 1 {
+		t.Errorf("requests counted = %d, want 1 for OpenWeather only", got)
+	}
+	if got := testutil.ToFloat64(owAPIKeyValid); got != 1 {
+		t.Errorf("ow_api_key_valid = %v after a 401 of the fallback, want 1", got)
+	}
+}
+
+// stationSources returns the source labels of the series of the collector
+// labeled with the station
+func stationSources(t *testing.T, c prometheus.Collector, station string) []string {
+	t.Helper()
+	metrics := make(chan prometheus.Metric)
+	go func() {
+		c.Collect(metrics)
+		close(metrics)
+	}()
+	var sources []string
+	for m := range metrics {
+		var pb dto.Metric
+		if err := m.Write(&pb); err != nil {
+			t.Fatal(err)
+		}
+		if metricStation(&pb) != station {
+			continue
+		}
+		for _, label := range pb.GetLabel() {
+			if label.GetName() == "source" {
+				sources = append(sources, label.GetValue())
+			}
+		}
+	}
+	return sources
+}
+
+func TestUpdateGroupMetricsAirPollutionCoordinates(t *testing.T) {
+	server := newFixtureServer(t)
+	for _, station := range []string{"524901", "703448"} {
+		t.Cleanup(func() { removeStation(station) })
+	}
+
+	n, err := updateGroupMetrics(context.Background(), server.URL+"/data/2.5/group?id=524901,703448&appid=secret", "secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 2 {
+		t.Fatalf("updateGroupMetrics updated %d stations, want 2", n)
+	}
+
+	// The air pollution requests use the coordinates from the group response
+	want := [][2]string{{"55.7522", "37.6156"}, {"50.4333", "30.5167"}}
+	requests := server.requested(airPollutionPath)
+	if len(requests) != len(want) {
+		t.Fatalf("%d air pollution requests, want %d", len(requests), len(want))
+	}
+	for i, u := range requests {
+		query := u.Query()
+		if got := [2]string{query.Get("lat"), query.Get("lon")}; got != want[i] {
+			t.Errorf("air pollution request %d for lat, lon %v, want %v", i, got, want[i])
+		}
+		if query.Get("appid") != "secret" {
+			t.Errorf("air pollution request %d lacks the API key", i)
+		}
+	}
+}
+
+func TestPushFinalMetricsLocationLabels(t *testing.T) {
+	const station = "push-test"
+	owWeatherHumidity.WithLabelValues(station).Set(50)
+	setStationLabels(station, map[string]string{"customer": "acme"})
+	recordObservation("ow_weather_", station, time.Unix(1760620000, 0))
+	t.Cleanup(func() { deleteStationSeries(station) })
+
+	var pushed []*dto.MetricFamily
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		decoder := expfmt.NewDecoder(r.Body, expfmt.ResponseFormat(r.Header))
+		for {
+			var family dto.MetricFamily
+			if err := decoder.Decode(&family); err != nil {
+				break
+			}
+			pushed = append(pushed, &family)
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+
+	if err := pushFinalMetrics(server.URL, "test"); err != nil {
+		t.Fatal(err)
+	}
+	found := false
+	for _, family := range pushed {
+		if family.GetName() != "ow_weather_humidity" {
+			continue
+		}
+		for _, m := range family.GetMetric() {
+			if metricStation(m) != station {
+				continue
+			}
+			found = true
+			if !hasLabel(m, "customer") {
+				t.Error("pushed series lacks the location label customer")
+			}
+			if m.TimestampMs != nil {
+				t.Error("pushed series has a timestamp, which the Pushgateway rejects")
+			}
+		}
+	}
+	if !found {
+		t.Errorf("ow_weather_humidity of station %s was not pushed", station)
+	}
+}
+
+func TestHandleStatusCoordPrecision(t *testing.T) {
+	loc := location{Latitude: "52.520008", Longitude: "13.404954", Units: "metric"}
+	addLocationStatus(loc)
+	t.Cleanup(func() { removeLocationStatus(locationKey(loc)) })
+	coordPrecision = 1
+	t.Cleanup(func() { coordPrecision = -1 })
+
+	recorder := httptest.NewRecorder()
+	handleStatus(recorder, httptest.NewRequest(http.MethodGet, "/status", nil))
+	var statuses []locationStatus
+	if err := json.Unmarshal(recorder.Body.Bytes(), &statuses); err != nil {
+		t.Fatal(err)
+	}
+	for _, status := range statuses {
+		if strings.HasPrefix(status.Latitude, "52.52") || strings.HasPrefix(status.Longitude, "13.40") {
+			t.Errorf("/status lists %s,%s, want the coordinates rounded to 1 decimal place", status.Latitude, status.Longitude)
+		}
+	}
+	if !strings.Contains(recorder.Body.String(), `"latitude": "52.5"`) {
+		t.Errorf("/status = %s, want latitude 52.5", recorder.Body.String())
+	}
+}
+
+func TestUpdateFeelsLikeRateUnits(t *testing.T) {
+	const station = "feels-like-rate-test"
+	t.Cleanup(func() { deleteStationSeries(station) })
+
+	start := time.Date(2026, 1, 1, 12, 0, 0, 0, time.UTC)
+	updateFeelsLikeRate(station, "metric", start, 10)
+	// The same station in Fahrenheit must not be compared with the °C reading
+	updateFeelsLikeRate(station, "imperial", start.Add(30*time.Minute), 52)
+	updateFeelsLikeRate(station, "metric", start.Add(time.Hour), 12)
+	updateFeelsLikeRate(station, "imperial", start.Add(90*time.Minute), 55)
+
+	if got := testutil.ToFloat64(owWeatherFeelsLikeRate.WithLabelValues(station, "metric")); got != 2 {
+		t.Errorf("metric feels like rate = %v, want 2", got)
+	}
+	if got := testutil.ToFloat64(owWeatherFeelsLikeRate.WithLabelValues(station, "imperial")); got != 3 {
+		t.Errorf("imperial feels like rate = %v, want 3", got)
+	}
+}
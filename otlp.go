@@ -0,0 +1,129 @@
+//go:build otlp
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/exporters/otlp/otlpmetric/otlpmetrichttp"
+	"go.opentelemetry.io/otel/sdk/instrumentation"
+	sdkmetric "go.opentelemetry.io/otel/sdk/metric"
+	"go.opentelemetry.io/otel/sdk/metric/metricdata"
+	"go.opentelemetry.io/otel/sdk/resource"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+)
+
+func init() {
+	newOTLPExporter = newOTLPMeterProvider
+}
+
+// otlpPrefixes are the metric name prefixes mirrored to OTLP
+var otlpPrefixes = []string{"ow_weather_", "ow_air_pollution_", "ow_station_info"}
+
+// otlpMeterProvider mirrors the weather and air pollution metrics to an OTLP
+// endpoint over HTTP. Metrics are pushed after every update cycle instead of
+// on a timer, so each push carries fresh data.
+type otlpMeterProvider struct {
+	provider *sdkmetric.MeterProvider
+}
+
+func newOTLPMeterProvider(ctx context.Context, endpoint string, gatherer prometheus.Gatherer) (otlpExporter, error) {
+	exporter, err := otlpmetrichttp.New(ctx, otlpmetrichttp.WithEndpointURL(endpoint))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create OTLP exporter: %w", err)
+	}
+	// The interval is far longer than any scrape interval, pushes are
+	// triggered by push
+	reader := sdkmetric.NewPeriodicReader(exporter,
+		sdkmetric.WithProducer(gathererProducer{gatherer}),
+		sdkmetric.WithInterval(24*time.Hour),
+	)
+	provider := sdkmetric.NewMeterProvider(
+		sdkmetric.WithReader(reader),
+		sdkmetric.WithResource(resource.NewSchemaless(attribute.String("service.name", "openweather_exporter"))),
+	)
+	return &otlpMeterProvider{provider: provider}, nil
+}
+
+func (p *otlpMeterProvider) push(ctx context.Context) error {
+	return p.provider.ForceFlush(ctx)
+}
+
+func (p *otlpMeterProvider) shutdown(ctx context.Context) error {
+	return p.provider.Shutdown(ctx)
+}
+
+// gathererProducer converts the gauges and counters of a Prometheus gatherer
+// to OpenTelemetry metrics, with the labels as attributes
+type gathererProducer struct {
+	gatherer prometheus.Gatherer
+}
+
+func (p gathererProducer) Produce(ctx context.Context) ([]metricdata.ScopeMetrics, error) {
+	families, err := p.gatherer.Gather()
+	if err != nil {
+		return nil, err
+	}
+	now := clk.Now()
+	var metrics []metricdata.Metrics
+	for _, family := range families {
+		if !hasAnyPrefix(family.GetName(), otlpPrefixes) {
+			continue
+		}
+		switch family.GetType() {
+		case dto.MetricType_GAUGE:
+			gauge := metricdata.Gauge[float64]{}
+			for _, m := range family.GetMetric() {
+				gauge.DataPoints = append(gauge.DataPoints, metricdata.DataPoint[float64]{
+					Attributes: metricAttributes(m),
+					Time:       now,
+					Value:      m.GetGauge().GetValue(),
+				})
+			}
+			metrics = append(metrics, metricdata.Metrics{Name: family.GetName(), Description: family.GetHelp(), Data: gauge})
+		case dto.MetricType_COUNTER:
+			sum := metricdata.Sum[float64]{Temporality: metricdata.CumulativeTemporality, IsMonotonic: true}
+			for _, m := range family.GetMetric() {
+				sum.DataPoints = append(sum.DataPoints, metricdata.DataPoint[float64]{
+					Attributes: metricAttributes(m),
+					StartTime:  exporterStart,
+					Time:       now,
+					Value:      m.GetCounter().GetValue(),
+				})
+			}
+			metrics = append(metrics, metricdata.Metrics{Name: family.GetName(), Description: family.GetHelp(), Data: sum})
+		}
+	}
+	return []metricdata.ScopeMetrics{{
+		Scope:   instrumentation.Scope{Name: "weather_exporter"},
+		Metrics: metrics,
+	}}, nil
+}
+
+// exporterStart is the start time of the cumulative counters
+var exporterStart = time.Now()
+
+// metricAttributes returns the labels of a series as attributes
+func metricAttributes(m *dto.Metric) attribute.Set {
+	attrs := make([]attribute.KeyValue, 0, len(m.GetLabel()))
+	for _, label := range m.GetLabel() {
+		attrs = append(attrs, attribute.String(label.GetName(), label.GetValue()))
+	}
+	return attribute.NewSet(attrs...)
+}
+
+// hasAnyPrefix reports whether name starts with one of the prefixes
+func hasAnyPrefix(name string, prefixes []string) bool {
+	for _, prefix := range prefixes {
+		if strings.HasPrefix(name, prefix) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,51 @@
+package main
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Weather overview API response structure, part of One Call API 3.0
+type WeatherOverviewResponse struct {
+	Lat             float64 `json:"lat"`
+	Lon             float64 `json:"lon"`
+	Date            string  `json:"date"`
+	Units           string  `json:"units"`
+	WeatherOverview string  `json:"weather_overview"`
+}
+
+// overviewEnabled enables the weather overview fetch for every location
+var overviewEnabled bool
+
+var owWeatherOverviewInfo = newGaugeVec(
+	prometheus.GaugeOpts{
+		Name: "ow_weather_overview_info",
+		Help: "Human-readable summary of today's weather in the summary label (always 1)",
+	},
+	[]string{"station", "summary"},
+)
+
+func init() {
+	exporterRegistry.MustRegister(owWeatherOverviewInfo)
+}
+
+func overviewURL(latitude, longitude, apiKey, units string) string {
+	return fmt.Sprintf("%s/data/3.0/onecall/overview?lat=%s&lon=%s&appid=%s&units=%s", apiBaseURL, latitude, longitude, apiKey, units)
+}
+
+func fetchOverviewData(ctx context.Context, url string, station string) error {
+	var overview WeatherOverviewResponse
+	if err := fetchJSON(ctx, "overview", "weather overview", url, &overview); err != nil {
+		return err
+	}
+
+	// Replace the previous summary, so only the current one is exported
+	owWeatherOverviewInfo.DeletePartialMatch(prometheus.Labels{"station": station})
+	if overview.WeatherOverview != "" {
+		owWeatherOverviewInfo.WithLabelValues(station, overview.WeatherOverview).Set(1)
+	}
+
+	return nil
+}
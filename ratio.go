@@ -0,0 +1,76 @@
+package main
+
+import (
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var owScrapeSuccessRatio = newGaugeVec(
+	prometheus.GaugeOpts{
+		Name: "ow_scrape_success_ratio",
+		Help: "Share of updates of the station within SCRAPE_SUCCESS_WINDOW in which all requests succeeded",
+	},
+	[]string{"station"},
+)
+
+func init() {
+	exporterRegistry.MustRegister(owScrapeSuccessRatio)
+}
+
+// scrapeOutcome is the result of one update of a station
+type scrapeOutcome struct {
+	at      time.Time
+	success bool
+}
+
+// successRatio keeps the update outcomes per station within a sliding window.
+// The outcomes are kept in memory, so the window starts empty on restart.
+type successRatio struct {
+	mu       sync.Mutex
+	window   time.Duration
+	outcomes map[string][]scrapeOutcome
+}
+
+func newSuccessRatio(window time.Duration) *successRatio {
+	return &successRatio{window: window, outcomes: make(map[string][]scrapeOutcome)}
+}
+
+// record adds an outcome for the station at time now, drops outcomes that
+// left the window, and returns the share of successful ones
+func (r *successRatio) record(station string, success bool, now time.Time) float64 {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	outcomes := append(r.outcomes[station], scrapeOutcome{at: now, success: success})
+	start := 0
+	for start < len(outcomes) && !outcomes[start].at.After(now.Add(-r.window)) {
+		start++
+	}
+	outcomes = outcomes[start:]
+	r.outcomes[station] = outcomes
+
+	successes := 0
+	for _, outcome := range outcomes {
+		if outcome.success {
+			successes++
+		}
+	}
+	return float64(successes) / float64(len(outcomes))
+}
+
+// forget drops the outcomes of a station
+func (r *successRatio) forget(station string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	delete(r.outcomes, station)
+}
+
+// scrapeSuccess tracks ow_scrape_success_ratio, the window is set from
+// SCRAPE_SUCCESS_WINDOW at startup
+var scrapeSuccess = newSuccessRatio(time.Hour)
+
+// recordScrapeOutcome records the outcome of an update of the station
+func recordScrapeOutcome(station string, success bool) {
+	owScrapeSuccessRatio.WithLabelValues(station).Set(scrapeSuccess.record(station, success, clk.Now()))
+}
@@ -0,0 +1,55 @@
+package main
+
+import (
+	"net/http"
+	"sync"
+	"time"
+)
+
+// readiness tracks whether the exporter serves current data. Failed updates
+// are tolerated during the warmup period after startup, so transient errors
+// on deploy such as DNS not being ready yet do not flip readiness. Afterwards
+// the exporter is ready as long as an update succeeded within maxAge, so a
+// single failed cycle does not flip readiness either.
+type readiness struct {
+	mu          sync.Mutex
+	warmupUntil time.Time
+	maxAge      time.Duration
+	lastSuccess time.Time
+}
+
+func newReadiness(start time.Time, warmup, maxAge time.Duration) *readiness {
+	return &readiness{warmupUntil: start.Add(warmup), maxAge: maxAge}
+}
+
+// record updates the readiness with the outcome of an update cycle at time now
+func (r *readiness) record(success bool, now time.Time) {
+	if !success {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.lastSuccess = now
+}
+
+// ready reports whether the exporter is still warming up or an update
+// succeeded within maxAge at time now
+func (r *readiness) ready(now time.Time) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if now.Before(r.warmupUntil) {
+		return true
+	}
+	return !r.lastSuccess.IsZero() && now.Sub(r.lastSuccess) <= r.maxAge
+}
+
+// handler serves 200 when ready and 503 otherwise
+func (r *readiness) handler(clk clock) http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		if !r.ready(clk.Now()) {
+			http.Error(w, "not ready", http.StatusServiceUnavailable)
+			return
+		}
+		w.Write([]byte("ok\n"))
+	}
+}
@@ -0,0 +1,48 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestReadiness(t *testing.T) {
+	const interval = 5 * time.Minute
+	start := time.Date(2026, 1, 1, 12, 0, 0, 0, time.UTC)
+	r := newReadiness(start, interval, 2*interval)
+
+	// Updates every interval, the first ones fail during the warmup
+	steps := []struct {
+		success bool
+		ready   bool
+	}{
+		{false, true},  // 12:00, warming up
+		{true, true},   // 12:05, warmup over
+		{false, true},  // 12:10, a single failure is tolerated
+		{true, true},   // 12:15
+		{false, true},  // 12:20
+		{false, false}, // 12:25, failing for two intervals
+		{false, false}, // 12:30
+		{true, true},   // 12:35, recovered
+	}
+	for i, step := range steps {
+		// Cycles finish shortly after they start, readiness is checked until
+		// the next one starts
+		finished := start.Add(time.Duration(i)*interval + time.Second)
+		r.record(step.success, finished)
+		if got := r.ready(finished.Add(interval - 2*time.Second)); got != step.ready {
+			t.Errorf("cycle %d at %s: ready = %v, want %v", i, finished.Format("15:04"), got, step.ready)
+		}
+	}
+}
+
+func TestReadinessFailingSinceStartup(t *testing.T) {
+	start := time.Date(2026, 1, 1, 12, 0, 0, 0, time.UTC)
+	r := newReadiness(start, 5*time.Minute, 10*time.Minute)
+	r.record(false, start)
+	if !r.ready(start.Add(4 * time.Minute)) {
+		t.Error("not ready during the warmup")
+	}
+	if r.ready(start.Add(5 * time.Minute)) {
+		t.Error("ready after the warmup without any successful update")
+	}
+}
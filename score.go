@@ -0,0 +1,101 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var owWeatherScore = newGaugeVec(
+	prometheus.GaugeOpts{
+		Name: "ow_weather_score",
+		Help: "How nice it is outside from 0 (worst) to 100 (best), combining temperature, wind, precipitation, and air quality",
+	},
+	[]string{"station"},
+)
+
+func init() {
+	exporterRegistry.MustRegister(owWeatherScore)
+}
+
+// scoreWeights are the relative weights of the penalties in ow_weather_score,
+// overridable with SCORE_WEIGHTS
+var scoreWeights = map[string]float64{
+	"temp":          0.35,
+	"wind":          0.2,
+	"precipitation": 0.3,
+	"aqi":           0.15,
+}
+
+const (
+	// scoreTempRange is the distance in °C from the comfortable band at which
+	// the temperature penalty is highest
+	scoreTempRange = 15.0
+	// scoreMaxWind is the wind speed in m/s at which the wind penalty is
+	// highest, a near gale
+	scoreMaxWind = 14.0
+)
+
+// parseScoreWeights parses a comma separated list of name=weight pairs, e.g.
+// "temp=0.5,aqi=0.5". Weights that are not listed keep their default.
+func parseScoreWeights(value string) (map[string]float64, error) {
+	weights := make(map[string]float64, len(scoreWeights))
+	for name, weight := range scoreWeights {
+		weights[name] = weight
+	}
+	for _, entry := range strings.Split(value, ",") {
+		name, weight, ok := strings.Cut(strings.TrimSpace(entry), "=")
+		if !ok {
+			return nil, fmt.Errorf("weight %q must be in the form name=weight", entry)
+		}
+		name = strings.TrimSpace(name)
+		if _, known := weights[name]; !known {
+			return nil, fmt.Errorf("unknown weight %q, must be temp, wind, precipitation, or aqi", name)
+		}
+		w, err := strconv.ParseFloat(strings.TrimSpace(weight), 64)
+		if err != nil || w < 0 || math.IsInf(w, 0) {
+			return nil, fmt.Errorf("weight of %s must be a non-negative number, got %q", name, weight)
+		}
+		weights[name] = w
+	}
+	var total float64
+	for _, weight := range weights {
+		total += weight
+	}
+	if total == 0 {
+		return nil, fmt.Errorf("at least one weight must be greater than 0")
+	}
+	return weights, nil
+}
+
+// weatherScore combines penalties from 0 to 1 for the feels like temperature
+// outside the comfortable band, the wind speed, precipitation, and the AQI
+// into a score from 0 to 100
+func weatherScore(weather *WeatherResponse, units string, aqi int) float64 {
+	celsius := convertTemp(weather.Main.FeelsLike, units, "metric")
+	var tempDistance float64
+	if celsius < comfortLimits[1] {
+		tempDistance = comfortLimits[1] - celsius
+	} else if celsius >= comfortLimits[2] {
+		tempDistance = celsius - comfortLimits[2]
+	}
+	penalties := map[string]float64{
+		"temp": min(tempDistance/scoreTempRange, 1),
+		"wind": min(convertSpeed(weather.Wind.Speed, units, "metric")/scoreMaxWind, 1),
+		// AQI ranges from 1 (good) to 5 (very poor)
+		"aqi": min(max(float64(aqi-1)/4, 0), 1),
+	}
+	if isPrecipitating(weather) {
+		penalties["precipitation"] = 1
+	}
+
+	var total, penalty float64
+	for name, weight := range scoreWeights {
+		total += weight
+		penalty += weight * penalties[name]
+	}
+	return 100 * (1 - penalty/total)
+}
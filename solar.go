@@ -0,0 +1,82 @@
+package main
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Solar Radiation API response structures
+type SolarRadiationResponse struct {
+	Coord struct {
+		Lon float64 `json:"lon"`
+		Lat float64 `json:"lat"`
+	} `json:"coord"`
+	List []struct {
+		Radiation struct {
+			GHI   float64 `json:"ghi"`
+			DNI   float64 `json:"dni"`
+			DHI   float64 `json:"dhi"`
+			GHICs float64 `json:"ghi_cs"`
+			DNICs float64 `json:"dni_cs"`
+			DHICs float64 `json:"dhi_cs"`
+		} `json:"radiation"`
+		Dt int64 `json:"dt"`
+	} `json:"list"`
+}
+
+// solarEnabled enables the solar radiation fetch for every location
+var solarEnabled bool
+
+// Solar radiation metrics
+var (
+	owSolarGHI = newGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "ow_solar_ghi",
+			Help: "Global horizontal irradiance in W/m²",
+		},
+		[]string{"station"},
+	)
+	owSolarDNI = newGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "ow_solar_dni",
+			Help: "Direct normal irradiance in W/m²",
+		},
+		[]string{"station"},
+	)
+	owSolarDHI = newGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "ow_solar_dhi",
+			Help: "Diffuse horizontal irradiance in W/m²",
+		},
+		[]string{"station"},
+	)
+)
+
+func init() {
+	exporterRegistry.MustRegister(owSolarGHI)
+	exporterRegistry.MustRegister(owSolarDNI)
+	exporterRegistry.MustRegister(owSolarDHI)
+}
+
+func solarRadiationURL(latitude, longitude, apiKey string) string {
+	return fmt.Sprintf("%s/data/2.5/solar_radiation?lat=%s&lon=%s&appid=%s", apiBaseURL, latitude, longitude, apiKey)
+}
+
+func fetchSolarRadiationData(ctx context.Context, url string, station string) error {
+	var solar SolarRadiationResponse
+	if err := fetchJSON(ctx, "solar_radiation", "solar radiation", url, &solar); err != nil {
+		return err
+	}
+
+	// Update solar radiation metrics
+	if len(solar.List) > 0 {
+		data := solar.List[0]
+		owSolarGHI.WithLabelValues(station).Set(roundValue(data.Radiation.GHI))
+		owSolarDNI.WithLabelValues(station).Set(roundValue(data.Radiation.DNI))
+		owSolarDHI.WithLabelValues(station).Set(roundValue(data.Radiation.DHI))
+	}
+
+	return nil
+}
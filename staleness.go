@@ -0,0 +1,101 @@
+package main
+
+import (
+	"context"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var owStationLastSuccess = newGaugeVec(
+	prometheus.GaugeOpts{
+		Name: "ow_station_last_success_timestamp_seconds",
+		Help: "Time of the last successful weather update of the station since unix epoch in seconds",
+	},
+	[]string{"station"},
+)
+
+func init() {
+	exporterRegistry.MustRegister(owStationLastSuccess)
+}
+
+// stalenessCheckInterval is how often stations are checked against MAX_METRIC_AGE
+const stalenessCheckInterval = time.Minute
+
+var (
+	lastSuccessMu sync.Mutex
+	lastSuccess   = make(map[string]time.Time)
+)
+
+// markStationUpdated records a successful weather update of the station
+func markStationUpdated(station string, now time.Time) {
+	lastSuccessMu.Lock()
+	defer lastSuccessMu.Unlock()
+	lastSuccess[station] = now
+	owStationLastSuccess.WithLabelValues(station).Set(float64(now.Unix()))
+}
+
+// deleteStationSeries deletes all series of the station
+func deleteStationSeries(station string) {
+	defer lockStation(station)()
+	labels := prometheus.Labels{"station": station}
+	for _, vec := range stationMetrics() {
+		vec.DeletePartialMatch(labels)
+	}
+	owStationLastSuccess.DeletePartialMatch(labels)
+	forgetCondition(station)
+	forgetIdenticalReadings(station)
+	forgetObservations(station)
+	scrapeSuccess.forget(station)
+	// A station that comes back starts its derived metrics from scratch
+	// rather than from samples taken before it went away
+	for _, history := range []*stationHistory{pressureHistory, windHistory, aqiHistory, feelsLikeHistory} {
+		history.forget(station)
+	}
+	rainToday.forget(station)
+	tempAverage.forget(station)
+	windSpeedAverage.forget(station)
+	forecastTemps.forget(station)
+	setStationLabels(station, nil)
+}
+
+// removeStation deletes all series of a station that is no longer monitored
+func removeStation(station string) {
+	lastSuccessMu.Lock()
+	defer lastSuccessMu.Unlock()
+	deleteStationSeries(station)
+	delete(lastSuccess, station)
+}
+
+// deleteStaleStations deletes all series of stations that have not been
+// updated successfully within maxAge, so Prometheus sees the data disappear
+// instead of the last values being reported forever.
+func deleteStaleStations(now time.Time, maxAge time.Duration) {
+	lastSuccessMu.Lock()
+	defer lastSuccessMu.Unlock()
+
+	for station, updated := range lastSuccess {
+		if now.Sub(updated) <= maxAge {
+			continue
+		}
+		deleteStationSeries(station)
+		delete(lastSuccess, station)
+		log.Printf("Deleted metrics of station %s, last updated %s ago", station, now.Sub(updated).Round(time.Second))
+	}
+}
+
+// runStalenessCheck periodically deletes stale stations until ctx is done
+func runStalenessCheck(ctx context.Context, clk clock, maxAge time.Duration) {
+	ticker := clk.NewTicker(stalenessCheckInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C():
+			deleteStaleStations(clk.Now(), maxAge)
+		}
+	}
+}
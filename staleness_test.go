@@ -0,0 +1,77 @@
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestStalenessCheck(t *testing.T) {
+	newFixtureServer(t)
+	fake := useFakeClock(t, time.Date(2026, 1, 1, 12, 0, 0, 0, time.UTC))
+
+	loc := location{Latitude: "40.015", Longitude: "-105.2705", Units: "standard"}
+	loc.buildURLs("secret")
+	if _, err := updateMetrics(context.Background(), loc); err != nil {
+		t.Fatal(err)
+	}
+	// The station of the weather fixture
+	const station = "5574991"
+	t.Cleanup(func() { removeStation(station) })
+
+	const maxAge = 10 * time.Minute
+	fake.advance(maxAge)
+	deleteStaleStations(fake.Now(), maxAge)
+	if n := stationSeries(t, owStationLastSuccess, station); n != 1 {
+		t.Fatalf("station deleted after %s with MAX_METRIC_AGE=%s", maxAge, maxAge)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go runStalenessCheck(ctx, fake, maxAge)
+	fake.waitTicker(t)
+	fake.advance(stalenessCheckInterval)
+
+	deadline := time.Now().Add(time.Second)
+	for stationSeries(t, owStationLastSuccess, station) != 0 {
+		if time.Now().After(deadline) {
+			t.Fatalf("station not deleted %s after its last update", maxAge+stalenessCheckInterval)
+		}
+		time.Sleep(time.Millisecond)
+	}
+	if n := stationSeries(t, owWeatherHumidity, station); n != 0 {
+		t.Errorf("stale station has %d ow_weather_humidity series, want 0", n)
+	}
+}
+
+func TestDeleteStationSeriesForgetsHistory(t *testing.T) {
+	const station = "forget-test"
+	const other = station + "-other"
+	t.Cleanup(func() {
+		deleteStationSeries(station)
+		deleteStationSeries(other)
+	})
+
+	observed := time.Date(2026, 1, 1, 12, 0, 0, 0, time.UTC)
+	for _, s := range []string{station, other} {
+		pressureHistory.Add(s, observed, 1013)
+		feelsLikeHistory.Add(s+"/metric", observed, 10)
+		tempAverage.Update(s, 10, 0.5)
+	}
+	deleteStationSeries(station)
+
+	later := observed.Add(time.Hour)
+	if n := len(pressureHistory.Add(station, later, 1010)); n != 1 {
+		t.Errorf("pressure history has %d samples after deletion, want 1", n)
+	}
+	if n := len(feelsLikeHistory.Add(station+"/metric", later, 12)); n != 1 {
+		t.Errorf("feels like history has %d samples after deletion, want 1", n)
+	}
+	if got := tempAverage.Update(station, 20, 0.5); got != 20 {
+		t.Errorf("moving average = %v after deletion, want the new value 20", got)
+	}
+	// Other stations keep their state, even with the station ID as prefix
+	if n := len(feelsLikeHistory.Add(other+"/metric", later, 12)); n != 2 {
+		t.Errorf("feels like history of %s has %d samples, want 2", other, n)
+	}
+}
@@ -0,0 +1,141 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"regexp"
+	"sort"
+	"sync"
+	"time"
+)
+
+// locationStatus is the outcome of the most recent update of a location
+type locationStatus struct {
+	Name        string     `json:"name,omitempty"`
+	Latitude    string     `json:"latitude"`
+	Longitude   string     `json:"longitude"`
+	Units       string     `json:"units"`
+	Station     string     `json:"station,omitempty"`
+	LastSuccess *time.Time `json:"last_success,omitempty"`
+	LastError   string     `json:"last_error,omitempty"`
+	Temperature *float64   `json:"temperature,omitempty"`
+}
+
+var (
+	locationStatusesMu sync.Mutex
+	// locationStatuses is keyed by the configured coordinates, or by station
+	// for CITY_IDS
+	locationStatuses = make(map[string]*locationStatus)
+)
+
+// appidPattern matches the API key in URLs quoted by HTTP client errors
+var appidPattern = regexp.MustCompile(`appid=[^&"\s]*`)
+
+// redactError returns the error message without the API key
+func redactError(err error) string {
+	return appidPattern.ReplaceAllString(err.Error(), "appid=REDACTED")
+}
+
+// locationStatusFor returns the status entry for key, creating it from loc.
+// The caller must hold locationStatusesMu.
+func locationStatusFor(key string, loc location) *locationStatus {
+	status, ok := locationStatuses[key]
+	if !ok {
+		status = &locationStatus{
+			Name:      loc.Name,
+			Latitude:  loc.Latitude,
+			Longitude: loc.Longitude,
+			Units:     loc.Units,
+		}
+		locationStatuses[key] = status
+	}
+	return status
+}
+
+// addLocationStatus lists a configured location before its first update
+func addLocationStatus(loc location) {
+	locationStatusesMu.Lock()
+	defer locationStatusesMu.Unlock()
+	locationStatusFor(locationKey(loc), loc)
+}
+
+// removeLocationStatus drops a location and returns its station, if known
+func removeLocationStatus(key string) string {
+	locationStatusesMu.Lock()
+	defer locationStatusesMu.Unlock()
+	var station string
+	if status, ok := locationStatuses[key]; ok {
+		station = status.Station
+		delete(locationStatuses, key)
+	}
+	return station
+}
+
+// recordLocationStatus records the outcome of an update. weather is nil when
+// the weather could not be fetched, err holds any error of the update.
+func recordLocationStatus(key string, loc location, station string, weather *WeatherResponse, err error) {
+	locationStatusesMu.Lock()
+	defer locationStatusesMu.Unlock()
+	status := locationStatusFor(key, loc)
+	if weather != nil {
+		now := clk.Now()
+		temp := weather.Main.Temp
+		status.Station = station
+		status.LastSuccess = &now
+		status.Temperature = &temp
+		if status.Name == "" {
+			status.Name = weather.Name
+		}
+	}
+	status.LastError = ""
+	if err != nil {
+		status.LastError = redactError(err)
+	}
+	// Failures before the first success have no station to count them for
+	if status.Station != "" {
+		recordScrapeOutcome(status.Station, err == nil)
+	}
+}
+
+// recordStatusError records an error for every known location, for failures
+// that affect all of them such as a failed group request
+func recordStatusError(err error) {
+	locationStatusesMu.Lock()
+	defer locationStatusesMu.Unlock()
+	for _, status := range locationStatuses {
+		status.LastError = redactError(err)
+		if status.Station != "" {
+			recordScrapeOutcome(status.Station, false)
+		}
+	}
+}
+
+// handleStatus serves the status of all locations as pretty-printed JSON
+func handleStatus(w http.ResponseWriter, r *http.Request) {
+	locationStatusesMu.Lock()
+	statuses := make([]locationStatus, 0, len(locationStatuses))
+	for _, status := range locationStatuses {
+		entry := *status
+		// Rounded like the station info labels, so COORD_PRECISION also
+		// hides the exact location here
+		entry.Latitude = coordLabel(entry.Latitude)
+		entry.Longitude = coordLabel(entry.Longitude)
+		statuses = append(statuses, entry)
+	}
+	locationStatusesMu.Unlock()
+
+	sort.Slice(statuses, func(i, j int) bool {
+		if statuses[i].Name != statuses[j].Name {
+			return statuses[i].Name < statuses[j].Name
+		}
+		return statuses[i].Latitude+","+statuses[i].Longitude < statuses[j].Latitude+","+statuses[j].Longitude
+	})
+
+	out, err := json.MarshalIndent(statuses, "", "  ")
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(out)
+}
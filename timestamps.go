@@ -0,0 +1,78 @@
+package main
+
+import (
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+)
+
+// observationPrefixes are the metric name prefixes whose samples carry the
+// observation time with USE_OBSERVATION_TIMESTAMP, one per OpenWeather
+// endpoint
+var observationPrefixes = []string{"ow_weather_", "ow_air_pollution_"}
+
+// observationTimes holds the time of the latest observation per metric name
+// prefix and station
+var (
+	observationTimesMu sync.Mutex
+	observationTimes   = make(map[string]map[string]time.Time)
+)
+
+// recordObservation stores the observation time of the station's metrics
+// with the given prefix
+func recordObservation(prefix, station string, observed time.Time) {
+	observationTimesMu.Lock()
+	defer observationTimesMu.Unlock()
+	times, ok := observationTimes[prefix]
+	if !ok {
+		times = make(map[string]time.Time)
+		observationTimes[prefix] = times
+	}
+	times[station] = observed
+}
+
+// forgetObservations drops the observation times once the station's series
+// have been deleted
+func forgetObservations(station string) {
+	observationTimesMu.Lock()
+	defer observationTimesMu.Unlock()
+	for _, times := range observationTimes {
+		delete(times, station)
+	}
+}
+
+// observationTimestampGatherer sets the timestamp of weather and air
+// pollution samples to the time OpenWeather observed them, instead of
+// leaving it to the scrape time.
+type observationTimestampGatherer struct {
+	prometheus.Gatherer
+}
+
+func (g observationTimestampGatherer) Gather() ([]*dto.MetricFamily, error) {
+	families, err := g.Gatherer.Gather()
+
+	observationTimesMu.Lock()
+	defer observationTimesMu.Unlock()
+	for _, family := range families {
+		var times map[string]time.Time
+		for _, prefix := range observationPrefixes {
+			if strings.HasPrefix(family.GetName(), prefix) {
+				times = observationTimes[prefix]
+				break
+			}
+		}
+		if times == nil {
+			continue
+		}
+		for _, m := range family.GetMetric() {
+			if observed, ok := times[metricStation(m)]; ok {
+				ms := observed.UnixMilli()
+				m.TimestampMs = &ms
+			}
+		}
+	}
+	return families, err
+}
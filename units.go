@@ -0,0 +1,74 @@
+package main
+
+import "math"
+
+// convertTemp converts a temperature between the standard (Kelvin), metric
+// (Celsius), and imperial (Fahrenheit) unit systems.
+func convertTemp(value float64, from, to string) float64 {
+	if from == to {
+		return value
+	}
+
+	// Normalize to Celsius first
+	celsius := value
+	switch from {
+	case "standard":
+		celsius = value - 273.15
+	case "imperial":
+		celsius = (value - 32) * 5 / 9
+	}
+
+	switch to {
+	case "standard":
+		return celsius + 273.15
+	case "imperial":
+		return celsius*9/5 + 32
+	}
+	return celsius
+}
+
+// metersPerSecondPerMph converts miles per hour to meters per second
+const metersPerSecondPerMph = 0.44704
+
+// convertSpeed converts a speed between unit systems. Standard and metric use
+// meters per second, imperial uses miles per hour.
+func convertSpeed(value float64, from, to string) float64 {
+	if from == "imperial" {
+		value *= metersPerSecondPerMph
+	}
+	if to == "imperial" {
+		value /= metersPerSecondPerMph
+	}
+	return value
+}
+
+// metersPerMile converts miles to meters
+const metersPerMile = 1609.344
+
+// convertDistance converts a distance between unit systems. Standard and
+// metric use meters, imperial uses miles. Note that OpenWeather reports
+// visibility in meters regardless of the units parameter.
+func convertDistance(value float64, from, to string) float64 {
+	if from == "imperial" {
+		value *= metersPerMile
+	}
+	if to == "imperial" {
+		value /= metersPerMile
+	}
+	return value
+}
+
+// inHgPerHPa converts hectopascals to inches of mercury. OpenWeather reports
+// pressure in hPa regardless of the unit system.
+const inHgPerHPa = 0.02953
+
+// absoluteHumidity returns the water vapor content of the air in g/m³ from the
+// temperature in Celsius and the relative humidity in percent, using the
+// Magnus formula for the saturation vapor pressure over water.
+func absoluteHumidity(celsius, relativeHumidity float64) float64 {
+	// Saturation vapor pressure in hPa
+	saturation := 6.112 * math.Exp(17.67*celsius/(celsius+243.5))
+	// Ideal gas law with the specific gas constant of water vapor of
+	// 461.5 J/(kg·K), converting hPa to Pa, percent to a fraction, and kg to g
+	return saturation * relativeHumidity * 2.1674 / (celsius + 273.15)
+}
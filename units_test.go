@@ -0,0 +1,128 @@
+package main
+
+import (
+	"math"
+	"testing"
+)
+
+// tolerance is the allowed difference of floating point conversions
+const tolerance = 1e-9
+
+func TestConvertTemp(t *testing.T) {
+	tests := []struct {
+		value    float64
+		from, to string
+		want     float64
+	}{
+		{273.15, "standard", "metric", 0},
+		{273.15, "standard", "imperial", 32},
+		{0, "metric", "standard", 273.15},
+		{0, "metric", "imperial", 32},
+		{32, "imperial", "metric", 0},
+		{32, "imperial", "standard", 273.15},
+		{100, "metric", "imperial", 212},
+		{-40, "metric", "imperial", -40},
+		{0, "standard", "metric", -273.15},
+	}
+	for _, tt := range tests {
+		if got := convertTemp(tt.value, tt.from, tt.to); math.Abs(got-tt.want) > tolerance {
+			t.Errorf("convertTemp(%v, %s, %s) = %v, want %v", tt.value, tt.from, tt.to, got, tt.want)
+		}
+	}
+}
+
+func TestConvertSpeed(t *testing.T) {
+	tests := []struct {
+		value    float64
+		from, to string
+		want     float64
+	}{
+		{0, "metric", "imperial", 0},
+		{1, "imperial", "metric", 0.44704},
+		{1, "imperial", "standard", 0.44704},
+		{0.44704, "metric", "imperial", 1},
+		{10, "standard", "metric", 10},
+		{10, "metric", "standard", 10},
+	}
+	for _, tt := range tests {
+		if got := convertSpeed(tt.value, tt.from, tt.to); math.Abs(got-tt.want) > tolerance {
+			t.Errorf("convertSpeed(%v, %s, %s) = %v, want %v", tt.value, tt.from, tt.to, got, tt.want)
+		}
+	}
+}
+
+func TestConvertDistance(t *testing.T) {
+	tests := []struct {
+		value    float64
+		from, to string
+		want     float64
+	}{
+		{0, "metric", "imperial", 0},
+		{1, "imperial", "metric", 1609.344},
+		{1609.344, "standard", "imperial", 1},
+		{10000, "metric", "standard", 10000},
+	}
+	for _, tt := range tests {
+		if got := convertDistance(tt.value, tt.from, tt.to); math.Abs(got-tt.want) > tolerance {
+			t.Errorf("convertDistance(%v, %s, %s) = %v, want %v", tt.value, tt.from, tt.to, got, tt.want)
+		}
+	}
+}
+
+// conversions are the unit conversion helpers by name
+var conversions = map[string]func(value float64, from, to string) float64{
+	"convertTemp":     convertTemp,
+	"convertSpeed":    convertSpeed,
+	"convertDistance": convertDistance,
+}
+
+// unitSystems are the unit systems accepted by UNITS
+var unitSystems = []string{"standard", "metric", "imperial"}
+
+func TestConvertIdentity(t *testing.T) {
+	for name, convert := range conversions {
+		for _, units := range unitSystems {
+			for _, value := range []float64{-40, 0, 12.5, 273.15} {
+				if got := convert(value, units, units); got != value {
+					t.Errorf("%s(%v, %s, %s) = %v, want the value unchanged", name, value, units, units, got)
+				}
+			}
+		}
+	}
+}
+
+func TestConvertRoundTrip(t *testing.T) {
+	for name, convert := range conversions {
+		for _, from := range unitSystems {
+			for _, to := range unitSystems {
+				for _, value := range []float64{-40, 0, 12.5, 273.15} {
+					if got := convert(convert(value, from, to), to, from); math.Abs(got-value) > tolerance {
+						t.Errorf("%s from %s to %s and back = %v, want %v", name, from, to, got, value)
+					}
+				}
+			}
+		}
+	}
+}
+
+func TestAbsoluteHumidity(t *testing.T) {
+	tests := []struct {
+		temp             float64
+		units            string
+		relativeHumidity float64
+		want             float64
+	}{
+		{20, "metric", 50, 8.6},
+		{30, "metric", 80, 24.3},
+		{0, "metric", 100, 4.8},
+		{293.15, "standard", 50, 8.6},
+		{86, "imperial", 80, 24.3},
+		{20, "metric", 0, 0},
+	}
+	for _, tt := range tests {
+		got := absoluteHumidity(convertTemp(tt.temp, tt.units, "metric"), tt.relativeHumidity)
+		if math.Abs(got-tt.want) > 0.05 {
+			t.Errorf("absolute humidity at %v %s and %v%% = %.2f g/m³, want %.1f", tt.temp, tt.units, tt.relativeHumidity, got, tt.want)
+		}
+	}
+}
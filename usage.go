@@ -0,0 +1,99 @@
+package main
+
+import (
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// API usage metrics
+var (
+	owAPIRequestsDay = prometheus.NewGauge(
+		prometheus.GaugeOpts{
+			Name: "ow_api_requests_day",
+			Help: "Number of OpenWeather API requests made today in USAGE_TIMEZONE",
+		},
+	)
+	owAPIRequestsMonth = prometheus.NewGauge(
+		prometheus.GaugeOpts{
+			Name: "ow_api_requests_month",
+			Help: "Number of OpenWeather API requests made this month in USAGE_TIMEZONE",
+		},
+	)
+	owAPIReceivedBytes = prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Name: "ow_api_received_bytes_total",
+			Help: "Total size of OpenWeather API response bodies in bytes",
+		},
+	)
+)
+
+func init() {
+	exporterRegistry.MustRegister(owAPIRequestsDay)
+	exporterRegistry.MustRegister(owAPIRequestsMonth)
+	exporterRegistry.MustRegister(owAPIReceivedBytes)
+}
+
+// usageTimezone is the timezone in which the daily and monthly request counts
+// reset. OpenWeather bills calendar months in UTC.
+var usageTimezone = time.UTC
+
+// usageRollup counts the API requests of the current day and month
+type usageRollup struct {
+	Day           string `json:"day"`
+	DayRequests   int    `json:"day_requests"`
+	Month         string `json:"month"`
+	MonthRequests int    `json:"month_requests"`
+}
+
+var (
+	usageMu sync.Mutex
+	usage   usageRollup
+)
+
+// roll resets the counts once the day or month has changed
+func (u *usageRollup) roll(now time.Time) {
+	local := now.In(usageTimezone)
+	if day := local.Format("2006-01-02"); u.Day != day {
+		u.Day = day
+		u.DayRequests = 0
+	}
+	if month := local.Format("2006-01"); u.Month != month {
+		u.Month = month
+		u.MonthRequests = 0
+	}
+}
+
+// setUsageMetrics exports the counts. The caller must hold usageMu.
+func setUsageMetrics() {
+	owAPIRequestsDay.Set(float64(usage.DayRequests))
+	owAPIRequestsMonth.Set(float64(usage.MonthRequests))
+}
+
+// countAPIRequest adds a request made at now to the daily and monthly counts
+func countAPIRequest(now time.Time) {
+	usageMu.Lock()
+	defer usageMu.Unlock()
+	usage.roll(now)
+	usage.DayRequests++
+	usage.MonthRequests++
+	setUsageMetrics()
+}
+
+// restoreUsage continues the counts persisted before a restart, unless the
+// day or month has changed since
+func restoreUsage(u usageRollup, now time.Time) {
+	usageMu.Lock()
+	defer usageMu.Unlock()
+	usage = u
+	usage.roll(now)
+	setUsageMetrics()
+}
+
+// currentUsage returns a copy of the counts
+func currentUsage() usageRollup {
+	usageMu.Lock()
+	defer usageMu.Unlock()
+	return usage
+}